@@ -5,6 +5,7 @@ go 1.25.5
 toolchain go1.26.2
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/Songmu/axslogparser v1.4.0
 	github.com/Songmu/postailer v0.0.0-20181014062912-daaa1ba9cc39
 	github.com/Songmu/timeout v0.4.0
@@ -0,0 +1,72 @@
+// Package errorformat writes the failure of the plugins to fetch the metrics in the format given by -error-format.
+package errorformat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// formats of the error written to stderr when the metrics can't be fetched
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// jsonError is the error written with -error-format=json.
+type jsonError struct {
+	Plugin string `json:"plugin"`
+	Error  string `json:"error"`
+	Target string `json:"target"`
+	// Transient tells whether the error is likely to be resolved by the next run, such as a timeout.
+	Transient bool `json:"transient"`
+}
+
+// WriteJSON writes err of the plugin to fetch the metrics from target as a single-line JSON object to w.
+func WriteJSON(w io.Writer, plugin, target string, err error) error {
+	return json.NewEncoder(w).Encode(jsonError{
+		Plugin:    plugin,
+		Error:     err.Error(),
+		Target:    target,
+		Transient: IsTransient(err),
+	})
+}
+
+// Exit writes err of the plugin to fetch the metrics from target to stderr in the format and exits.
+// It falls back to the plain text if the JSON can't be written.
+func Exit(format, plugin, target string, err error) {
+	if format == JSON {
+		if werr := WriteJSON(os.Stderr, plugin, target, err); werr == nil {
+			os.Exit(1)
+		}
+	}
+	log.Fatalln(err)
+}
+
+// statusError is implemented by the errors of the plugins for the error statuses of HTTP.
+type statusError interface {
+	error
+	HTTPStatusCode() int
+}
+
+// IsTransient reports whether err to fetch the metrics is likely to be resolved by retrying,
+// such as a timeout, a refused connection, or a 5xx or 429 response.
+// The others, such as a 4xx response to the credentials or a malformed response, are permanent.
+func IsTransient(err error) bool {
+	var serr statusError
+	if errors.As(err, &serr) {
+		code := serr.HTTPStatusCode()
+		return code == http.StatusTooManyRequests || code >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
@@ -0,0 +1,61 @@
+package errorformat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testStatusError struct {
+	code int
+}
+
+func (e *testStatusError) Error() string {
+	return fmt.Sprintf("unexpected status: %d", e.code)
+}
+
+func (e *testStatusError) HTTPStatusCode() int {
+	return e.code
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteJSON(&buf, "elasticsearch", "http://localhost:9200", errors.New(`/_nodes/_local/stats: unexpected status: 401 Unauthorized`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"plugin":"elasticsearch","error":"/_nodes/_local/stats: unexpected status: 401 Unauthorized","target":"http://localhost:9200","transient":false}`+"\n", buf.String())
+
+	buf.Reset()
+	err = WriteJSON(&buf, "haproxy", "/run/haproxy/admin.sock", fmt.Errorf("dial unix /run/haproxy/admin.sock: %w", syscall.ECONNREFUSED))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"plugin":"haproxy","error":"dial unix /run/haproxy/admin.sock: connection refused","target":"/run/haproxy/admin.sock","transient":true}`+"\n", buf.String())
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"503", fmt.Errorf("/_nodes/_local/stats: %w", &testStatusError{503}), true},
+		{"429", &testStatusError{429}, true},
+		{"401", &testStatusError{401}, false},
+		{"timeout", fmt.Errorf("/: %w", context.DeadlineExceeded), true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"joined", errors.Join(errors.New("/_cluster/health: unknown"), &testStatusError{502}), true},
+		{"malformed", &json.SyntaxError{}, false},
+		{"other", errors.New("unknown"), false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, IsTransient(tt.err), tt.name)
+	}
+}
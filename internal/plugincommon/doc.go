@@ -0,0 +1,2 @@
+// Package plugincommon provides the flags and the helpers shared by the plugins.
+package plugincommon
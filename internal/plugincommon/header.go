@@ -0,0 +1,38 @@
+package plugincommon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HeaderFlag represents repeatable -header flag.
+type HeaderFlag []string
+
+func (h *HeaderFlag) String() string {
+	return fmt.Sprintf("%v", *h)
+}
+
+// Set implements flag.Value interface.
+func (h *HeaderFlag) Set(v string) error {
+	k, _, ok := strings.Cut(v, ":")
+	if !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("invalid header %q: must be \"Name: Value\"", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// SetHeaders sets headers formatted as "Name: Value" to req.
+func SetHeaders(req *http.Request, headers []string) {
+	for _, h := range headers {
+		k, v, _ := strings.Cut(h, ":")
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+		if http.CanonicalHeaderKey(k) == "Host" {
+			req.Host = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+}
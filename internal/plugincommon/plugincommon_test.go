@@ -0,0 +1,79 @@
+package plugincommon
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	mphelper "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderFlag_Set(t *testing.T) {
+	var h HeaderFlag
+	assert.NoError(t, h.Set("X-Api-Gateway-Key:  secret "))
+	assert.Error(t, h.Set("X-Api-Gateway-Key"))
+	assert.Error(t, h.Set(": secret"))
+	assert.Equal(t, HeaderFlag{"X-Api-Gateway-Key:  secret "}, h)
+}
+
+func TestSetHeaders(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHeaders(req, []string{"X-Api-Gateway-Key:  secret ", "host: example.com"})
+	assert.Equal(t, "secret", req.Header.Get("X-Api-Gateway-Key"))
+	assert.Equal(t, "example.com", req.Host)
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	v, err := ParseTLSVersion("1.3")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0x0304, v)
+
+	_, err = ParseTLSVersion("1.4")
+	assert.EqualError(t, err, "unknown TLS version: 1.4")
+}
+
+func TestCheckTempfile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, CheckTempfile(filepath.Join(dir, "tempfile")))
+
+	existing := filepath.Join(dir, "existing")
+	assert.NoError(t, os.WriteFile(existing, []byte("{}"), 0600))
+	assert.NoError(t, CheckTempfile(existing))
+
+	assert.Error(t, CheckTempfile(filepath.Join(dir, "nonexistent", "tempfile")))
+}
+
+func TestScaleDiffs(t *testing.T) {
+	metrics := []mp.Metrics{
+		{Name: "sessions", Diff: true},
+		{Name: "bytes", Diff: true, Scale: 8},
+		{Name: "current"},
+	}
+	graphdef := map[string]mp.Graphs{"haproxy.total": {Metrics: metrics}}
+	ScaleDiffs(graphdef, 5*time.Minute)
+	assert.Equal(t, []mp.Metrics{
+		{Name: "sessions", Diff: true, Scale: 5},
+		{Name: "bytes", Diff: true, Scale: 40},
+		{Name: "current"},
+	}, graphdef["haproxy.total"].Metrics)
+	assert.Zero(t, metrics[0].Scale, "the metrics shared by the definitions are not modified")
+}
+
+func TestScaleHelperDiffs(t *testing.T) {
+	graphdef := map[string]mphelper.Graphs{"php-fpm.processes": {Metrics: []mphelper.Metrics{
+		{Name: "accepted_conn", Diff: true, Type: "uint64"},
+		{Name: "active_processes", Type: "uint64"},
+	}}}
+	ScaleHelperDiffs(graphdef, 30*time.Second)
+	assert.Equal(t, []mphelper.Metrics{
+		{Name: "accepted_conn", Diff: true, Type: "float64", Scale: 0.5},
+		{Name: "active_processes", Type: "uint64"},
+	}, graphdef["php-fpm.processes"].Metrics)
+}
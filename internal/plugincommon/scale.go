@@ -0,0 +1,45 @@
+package plugincommon
+
+import (
+	"slices"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	mphelper "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// ScaleDiffs scales the metrics taking differences, which are per minute, to per interval.
+func ScaleDiffs(graphdef map[string]mp.Graphs, interval time.Duration) {
+	for k, g := range graphdef {
+		g.Metrics = slices.Clone(g.Metrics)
+		for i, m := range g.Metrics {
+			if m.Diff {
+				g.Metrics[i].Scale = scale(m.Scale, interval)
+			}
+		}
+		graphdef[k] = g
+	}
+}
+
+// ScaleHelperDiffs is ScaleDiffs for the graphs of go-mackerel-plugin-helper.
+func ScaleHelperDiffs(graphdef map[string]mphelper.Graphs, interval time.Duration) {
+	for k, g := range graphdef {
+		g.Metrics = slices.Clone(g.Metrics)
+		for i, m := range g.Metrics {
+			if m.Diff {
+				g.Metrics[i].Scale = scale(m.Scale, interval)
+				// the scale is truncated to an integer for uint64
+				g.Metrics[i].Type = "float64"
+			}
+		}
+		graphdef[k] = g
+	}
+}
+
+// scale returns the scale of a metric per minute, which is 1 if it is zero, multiplied to per interval.
+func scale(s float64, interval time.Duration) float64 {
+	if s == 0 {
+		s = 1
+	}
+	return s * (float64(interval) / float64(time.Minute))
+}
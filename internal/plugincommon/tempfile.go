@@ -0,0 +1,30 @@
+package plugincommon
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mackerelio/golib/pluginutil"
+)
+
+// CheckTempfile reports an error if the tempfile at path can't be written.
+// When path is empty, it checks the plugin work directory where the tempfile is generated.
+func CheckTempfile(path string) error {
+	dir := pluginutil.PluginWorkDir()
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err == nil {
+			return f.Close()
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		dir = filepath.Dir(path)
+	}
+	f, err := os.CreateTemp(dir, ".mackerel-plugin-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,23 @@
+package plugincommon
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// tlsVersions maps the values of -tls-min-version to the TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns the TLS version of s such as "1.2".
+func ParseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version: %s", s)
+	}
+	return v, nil
+}
@@ -1,15 +1,31 @@
 package mpelasticsearch
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"log"
+	"maps"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
 	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/golib/pluginutil"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/errorformat"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/plugincommon"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -18,29 +34,69 @@ var logger = logging.GetLogger("metrics.plugin.elasticsearch")
 
 var metricPlace = map[string][]string{
 	"http_opened":                 {"http", "total_opened"},
+	"http_current_open":           {"http", "current_open"},
 	"total_indexing_index":        {"indices", "indexing", "index_total"},
 	"total_indexing_delete":       {"indices", "indexing", "delete_total"},
+	"indexing_throttle_time":      {"indices", "indexing", "throttle_time_in_millis"},
+	"indexing_index_current":      {"indices", "indexing", "index_current"},
+	"indexing_delete_current":     {"indices", "indexing", "delete_current"},
+	"indexing_noop_update_total":  {"indices", "indexing", "noop_update_total"},
 	"total_get":                   {"indices", "get", "total"},
+	"total_get_time":              {"indices", "get", "time_in_millis"},
+	"get_exists_total":            {"indices", "get", "exists_total"},
+	"get_missing_total":           {"indices", "get", "missing_total"},
 	"total_search_query":          {"indices", "search", "query_total"},
 	"total_search_fetch":          {"indices", "search", "fetch_total"},
+	"search_query_current":        {"indices", "search", "query_current"},
+	"search_fetch_current":        {"indices", "search", "fetch_current"},
 	"total_merges":                {"indices", "merges", "total"},
+	"merges_current":              {"indices", "merges", "current"},
+	"merges_current_docs":         {"indices", "merges", "current_docs"},
+	"merges_current_size":         {"indices", "merges", "current_size_in_bytes"},
 	"total_refresh":               {"indices", "refresh", "total"},
+	"refresh_external_total":      {"indices", "refresh", "external_total"},                // no value before v7.2
+	"refresh_external_time":       {"indices", "refresh", "external_total_time_in_millis"}, // no value before v7.2
+	"bulk_total_operations":       {"indices", "bulk", "total_operations"},                 // no value on older versions
+	"bulk_total_time":             {"indices", "bulk", "total_time_in_millis"},             // no value on older versions
 	"total_flush":                 {"indices", "flush", "total"},
+	"total_flush_periodic":        {"indices", "flush", "periodic"}, // no value before v5.0
 	"total_warmer":                {"indices", "warmer", "total"},
+	"recovery_throttle_time":      {"indices", "recovery", "throttle_time_in_millis"},
+	"total_warmer_time":           {"indices", "warmer", "total_time_in_millis"},
 	"total_percolate":             {"indices", "percolate", "total"}, // MISSINGv7 = no value after v7.0 (at least)
 	"total_suggest":               {"indices", "suggest", "total"},   // MISSINGv7
 	"docs_count":                  {"indices", "docs", "count"},
 	"docs_deleted":                {"indices", "docs", "deleted"},
+	"node_shard_count":            {"indices", "shard_stats", "total_count"}, // no value before v7.15
+	"store_size":                  {"indices", "store", "size_in_bytes"},
+	"store_reserved":              {"indices", "store", "reserved_in_bytes"}, // no value before v7.9
 	"fielddata_size":              {"indices", "fielddata", "memory_size_in_bytes"},
+	"completion_size":             {"indices", "completion", "size_in_bytes"},
 	"filter_cache_size":           {"indices", "filter_cache", "memory_size_in_bytes"}, // MISSINGv7
 	"segments_size":               {"indices", "segments", "memory_in_bytes"},
 	"segments_index_writer_size":  {"indices", "segments", "index_writer_memory_in_bytes"},
 	"segments_version_map_size":   {"indices", "segments", "version_map_memory_in_bytes"},
 	"segments_fixed_bit_set_size": {"indices", "segments", "fixed_bit_set_memory_in_bytes"},
+	"segments_terms_size":         {"indices", "segments", "terms_memory_in_bytes"},         // MISSINGv8
+	"segments_stored_fields_size": {"indices", "segments", "stored_fields_memory_in_bytes"}, // MISSINGv8
+	"segments_norms_size":         {"indices", "segments", "norms_memory_in_bytes"},         // MISSINGv8
+	"segments_points_size":        {"indices", "segments", "points_memory_in_bytes"},        // MISSINGv8
+	"segments_doc_values_size":    {"indices", "segments", "doc_values_memory_in_bytes"},    // MISSINGv8
 	"evictions_fielddata":         {"indices", "fielddata", "evictions"},
+	"query_cache_hit":             {"indices", "query_cache", "hit_count"},
+	"query_cache_miss":            {"indices", "query_cache", "miss_count"},
+	"query_cache_total_count":     {"indices", "query_cache", "total_count"},
+	"query_cache_count":           {"indices", "query_cache", "cache_size"}, // cache_count also counts the evicted entries
 	"evictions_filter_cache":      {"indices", "filter_cache", "evictions"}, // MISSINGv7
 	"heap_used":                   {"jvm", "mem", "heap_used_in_bytes"},
 	"heap_max":                    {"jvm", "mem", "heap_max_in_bytes"},
+	"jvm_threads_count":           {"jvm", "threads", "count"},
+	"jvm_threads_peak":            {"jvm", "threads", "peak_count"},
+	"jvm_direct_buffer_used":      {"jvm", "buffer_pools", "direct", "used_in_bytes"},
+	"jvm_mapped_buffer_used":      {"jvm", "buffer_pools", "mapped", "used_in_bytes"},
+	"jvm_pool_young_used":         {"jvm", "mem", "pools", "young", "used_in_bytes"},
+	"jvm_pool_survivor_used":      {"jvm", "mem", "pools", "survivor", "used_in_bytes"},
+	"jvm_pool_old_used":           {"jvm", "mem", "pools", "old", "used_in_bytes"},
 	"threads_generic":             {"thread_pool", "generic", "threads"},
 	"threads_index":               {"thread_pool", "index", "threads"},         // MISSINGv7
 	"threads_snapshot_data":       {"thread_pool", "snapshot_data", "threads"}, // MISSINGv7
@@ -60,14 +116,45 @@ var metricPlace = map[string][]string{
 	"threads_fetch_shard_started": {"thread_pool", "fetch_shard_started", "threads"},
 	"threads_fetch_shard_store":   {"thread_pool", "fetch_shard_store", "threads"},
 	"threads_listener":            {"thread_pool", "listener", "threads"}, // MISSINGv8
+	"write_rejected":              {"thread_pool", "write", "rejected"},   // no value before v6.3
+	"write_completed":             {"thread_pool", "write", "completed"},  // no value before v6.3
+	"active_generic":              {"thread_pool", "generic", "active"},
+	"active_get":                  {"thread_pool", "get", "active"},
+	"active_search":               {"thread_pool", "search", "active"},
+	"active_write":                {"thread_pool", "write", "active"}, // no value before v6.3
+	"active_management":           {"thread_pool", "management", "active"},
+	"active_refresh":              {"thread_pool", "refresh", "active"},
+	"active_flush":                {"thread_pool", "flush", "active"},
+	"completed_generic":           {"thread_pool", "generic", "completed"},
+	"completed_get":               {"thread_pool", "get", "completed"},
+	"completed_search":            {"thread_pool", "search", "completed"},
+	"completed_write":             {"thread_pool", "write", "completed"}, // no value before v6.3
+	"completed_management":        {"thread_pool", "management", "completed"},
+	"completed_refresh":           {"thread_pool", "refresh", "completed"},
+	"completed_flush":             {"thread_pool", "flush", "completed"},
 	"count_rx":                    {"transport", "rx_count"},
 	"count_tx":                    {"transport", "tx_count"},
 	"open_file_descriptors":       {"process", "open_file_descriptors"},
+	"max_file_descriptors":        {"process", "max_file_descriptors"},
+	"process_cpu_total":           {"process", "cpu", "total_in_millis"},
+	"cgroup_cpu_throttled":        {"os", "cgroup", "cpu", "stat", "time_throttled_nanos"}, // only on nodes running in a cgroup
 	"compilations":                {"script", "compilations"},
 	"cache_evictions":             {"script", "cache_evictions"},
 	"compilation_limit_triggered": {"script", "compilation_limit_triggered"},
 }
 
+var clusterHealthPlace = map[string][]string{
+	"number_of_nodes":           {"number_of_nodes"},
+	"number_of_data_nodes":      {"number_of_data_nodes"},
+	"active_primary_shards":     {"active_primary_shards"},
+	"active_shards":             {"active_shards"},
+	"relocating_shards":         {"relocating_shards"},
+	"initializing_shards":       {"initializing_shards"},
+	"unassigned_shards":         {"unassigned_shards"},
+	"delayed_unassigned_shards": {"delayed_unassigned_shards"},
+	"active_shards_percent":     {"active_shards_percent_as_number"},
+}
+
 func getFloatValue(s map[string]any, keys []string) (float64, error) {
 	var val float64
 	sm := s
@@ -92,6 +179,88 @@ func getFloatValue(s map[string]any, keys []string) (float64, error) {
 	return val, nil
 }
 
+// sumThreadPools returns the sum of the field over all thread pools of the node.
+func sumThreadPools(node map[string]any, field string) (float64, error) {
+	pools, ok := node["thread_pool"].(map[string]any)
+	if !ok {
+		return 0, errors.New("Cannot handle as a hash") // nolint
+	}
+	var sum float64
+	for _, v := range pools {
+		pool, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		if val, ok := pool[field].(float64); ok {
+			sum += val
+		}
+	}
+	return sum, nil
+}
+
+// maxAdaptiveSelection returns the max of the field over the nodes in the adaptive replica selection stats of the node.
+// ok is false if the node has not searched any node yet.
+func maxAdaptiveSelection(node map[string]any, field string) (v float64, ok bool) {
+	peers, _ := node["adaptive_selection"].(map[string]any)
+	for _, p := range peers {
+		peer, _ := p.(map[string]any)
+		if val, found := peer[field].(float64); found && (!ok || val > v) {
+			v, ok = val, true
+		}
+	}
+	return v, ok
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// scriptContexts returns the field of each script context of the node.
+// The contexts are listed in script.contexts, or script_cache.contexts on v7.9 and later,
+// as an array of objects with the name of the context.
+func scriptContexts(node map[string]any, field string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, section := range []string{"script", "script_cache"} {
+		s, _ := node[section].(map[string]any)
+		contexts, _ := s["contexts"].([]any)
+		for _, c := range contexts {
+			c, _ := c.(map[string]any)
+			name, _ := c["context"].(string)
+			if v, ok := c[field].(float64); ok && name != "" {
+				values[invalidMetricChars.ReplaceAllString(name, "_")] = v
+			}
+		}
+		if len(values) > 0 {
+			break
+		}
+	}
+	return values
+}
+
+// tlsConfig returns the TLS configuration to verify the server with the CA certificates in p.CAFile.
+// Without p.CAFile, the system pool is used, which honors SSL_CERT_FILE and SSL_CERT_DIR.
+func (p ElasticsearchPlugin) tlsConfig() (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: p.Insecure, MinVersion: p.TLSMinVersion}
+	if p.CAFile == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(p.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	c.RootCAs = x509.NewCertPool()
+	if !c.RootCAs.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", p.CAFile)
+	}
+	return c, nil
+}
+
+// hostname returns host without the port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
 // ElasticsearchPlugin mackerel plugin for Elasticsearch
 type ElasticsearchPlugin struct {
 	URI                  string
@@ -101,39 +270,279 @@ type ElasticsearchPlugin struct {
 	User                 string
 	Password             string
 	SuppressMissingError bool
+	ClusterHealth        bool
+	License              bool
+	Header               []string
+	StateFile            string
+	EmitScrapeDuration   bool
+	HostHeader           string
+	CAFile               string
+	TLSMinVersion        uint16
+	FielddataLimitBytes  uint64
+	ShardStates          bool
+	AdaptiveSelection    bool
+	ScriptContexts       bool
+	Interval             time.Duration
+	Timeout              time.Duration
+	RolePrefix           bool
+	Strict               bool
+	RequiredMetrics      []string
+	AttributePrefix      string
+
+	// role is shared between copies of the plugin to prepend the primary role of the node to Prefix after fetching the node stats.
+	role *string
+	// attribute is shared in the same way as role to prepend the value of the node attribute AttributePrefix.
+	attribute *string
+	// major is shared in the same way as role to leave out the metrics removed in the major version of Elasticsearch,
+	// 0 until it is detected, or -1 if it can't be.
+	major *int
+}
+
+// rolePriority lists the roles in order of precedence to choose the primary role of a node.
+// Data tier roles such as data_hot are counted as data.
+var rolePriority = []string{"master", "data", "ingest", "ml", "transform"}
+
+// primaryRole returns the primary role of a node with roles, or "coordinating" for a coordinating only node.
+func primaryRole(roles []any) string {
+	for _, r := range rolePriority {
+		for _, v := range roles {
+			if s, ok := v.(string); ok && (s == r || strings.HasPrefix(s, r+"_")) {
+				return r
+			}
+		}
+	}
+	return "coordinating"
+}
+
+// keyPrefix returns Prefix followed by the value of the node attribute AttributePrefix if it is set,
+// and the primary role of the node if RolePrefix is set.
+func (p ElasticsearchPlugin) keyPrefix() string {
+	prefix := p.Prefix
+	if p.AttributePrefix != "" {
+		// graph definitions are output before the attribute is fetched
+		attr := "#"
+		if p.attribute != nil && *p.attribute != "" {
+			attr = *p.attribute
+		}
+		prefix += "." + attr
+	}
+	if !p.RolePrefix {
+		return prefix
+	}
+	// graph definitions are output before the role is fetched
+	role := "#"
+	if p.role != nil && *p.role != "" {
+		role = *p.role
+	}
+	return prefix + "." + role
+}
+
+// unknownAttribute is the value prepended for the node without the attribute of -attribute-prefix.
+const unknownAttribute = "unknown"
+
+// nodeStatsPath is the endpoint of the stats of the node, which has the counters kept in the state.
+const nodeStatsPath = "/_nodes/_local/stats"
+
+type fetcher struct {
+	endpoint string
+	fetch    func(ctx context.Context) (map[string]float64, error)
+}
+
+func (p ElasticsearchPlugin) fetchers() []fetcher {
+	fetchers := []fetcher{
+		{nodeStatsPath, p.fetchNodeStats},
+	}
+	if p.ClusterHealth {
+		fetchers = append(fetchers, fetcher{"/_cluster/health", p.fetchClusterHealth})
+	}
+	if p.License {
+		fetchers = append(fetchers, fetcher{"/_license", p.fetchLicense})
+	}
+	if p.ShardStates {
+		fetchers = append(fetchers, fetcher{"/_cat/shards", p.fetchShardStates})
+	}
+	return fetchers
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p ElasticsearchPlugin) FetchMetrics() (map[string]float64, error) {
-	req, err := http.NewRequest(http.MethodGet, p.URI+"/_nodes/_local/stats", nil)
+	start := time.Now()
+	// the deadline is shared by all the requests, so that the scrape finishes in time however many are made
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	stat := make(map[string]float64)
+	var errs []error
+	var nodeStatsFailed bool
+	fetchers := p.fetchers()
+	for _, f := range fetchers {
+		s, err := f.fetch(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.endpoint, err))
+			nodeStatsFailed = nodeStatsFailed || f.endpoint == nodeStatsPath
+			continue
+		}
+		maps.Copy(stat, s)
+	}
+	if len(errs) == len(fetchers) {
+		return nil, errors.Join(errs...)
+	}
+	prev := loadState(p.StateFile)
+	// keep the previous state rather than the one without the counters of the node
+	if !nodeStatsFailed {
+		if err := saveState(p.StateFile, stat); err != nil {
+			logger.Warningf("Failed to save state: %s", err)
+		}
+	}
+	if ratio, ok := hitRatio(stat, prev, "query_cache_hit", "query_cache_miss"); ok {
+		stat["query_cache_hit_ratio"] = ratio
+	}
+	if ratio, ok := hitRatio(stat, prev, "write_rejected", "write_completed"); ok {
+		stat["write_rejection_ratio"] = ratio
+	}
+	if avg, ok := avgTime(stat, prev, "total_get_time", "total_get"); ok {
+		stat["get_avg_time_ms"] = avg
+	}
+	if avg, ok := avgTime(stat, prev, "total_warmer_time", "total_warmer"); ok {
+		stat["warmer_avg_time_ms"] = avg
+	}
+
+	// Report whatever succeeded; a failing endpoint shouldn't wipe all metrics.
+	for _, err := range errs {
+		logger.Errorf("Failed to fetch %s", err)
+	}
+	if p.EmitScrapeDuration {
+		stat["scrape_duration_ms"] = float64(time.Since(start)) / float64(time.Millisecond)
+	}
+	if p.Strict {
+		if missing := missingMetrics(stat, p.RequiredMetrics); len(missing) > 0 {
+			return nil, fmt.Errorf("required metrics are missing: %s", strings.Join(missing, ", "))
+		}
+	}
+	return stat, nil
+}
+
+// missingMetrics returns the names in required which stat doesn't have.
+func missingMetrics(stat map[string]float64, required []string) []string {
+	var missing []string
+	for _, name := range required {
+		if _, ok := stat[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// statusError is returned by getJSON when Elasticsearch responds with a non-200 status.
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status: " + e.Status
+}
+
+// HTTPStatusCode returns the status code for errorformat.IsTransient.
+func (e *statusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// requestError is returned by getJSON when the request to Elasticsearch fails,
+// telling the phase of the request in which it failed.
+type requestError struct {
+	Phase string
+	Err   error
+}
+
+func (e *requestError) Error() string {
+	return e.Phase + " failed: " + e.Err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.Err
+}
+
+// requestPhase returns the phase of the request in which err occurred, or "request" if it is unknown.
+func requestPhase(err error) string {
+	var (
+		dnsErr       *net.DNSError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		opErr        *net.OpError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return "DNS lookup"
+	case errors.As(err, &verifyErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr),
+		errors.As(err, &invalidErr), errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return "TLS handshake"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "connect"
+	case errors.As(err, &opErr) && opErr.Op == "read":
+		return "read"
+	case errors.Is(err, context.DeadlineExceeded):
+		// the deadline passed waiting for the response
+		return "read"
+	}
+	return "request"
+}
+
+// getJSON requests path of Elasticsearch and decodes the response into v.
+func (p ElasticsearchPlugin) getJSON(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URI+path, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	req.Header.Set("User-Agent", "mackerel-plugin-elasticsearch")
 	if p.User != "" && p.Password != "" {
 		req.SetBasicAuth(p.User, p.Password)
 	}
+	plugincommon.SetHeaders(req, p.Header)
+	tlsConfig, err := p.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if p.HostHeader != "" {
+		// verify the certificate for the virtual host rather than the forwarded address
+		req.Host = p.HostHeader
+		tlsConfig.ServerName = hostname(p.HostHeader)
+	}
 	client := http.Client{
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: p.Insecure},
+			TLSClientConfig: tlsConfig,
 		},
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return &requestError{Phase: requestPhase(err), Err: err}
 	}
 	defer resp.Body.Close()
 
-	stat := make(map[string]float64)
-	decoder := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
 
+func (p ElasticsearchPlugin) fetchNodeStats(ctx context.Context) (map[string]float64, error) {
 	var s map[string]any
-	err = decoder.Decode(&s)
-	if err != nil {
+	if err := p.getJSON(ctx, nodeStatsPath, &s); err != nil {
 		return nil, err
 	}
 
-	nodes := s["nodes"].(map[string]any)
+	stat := make(map[string]float64)
+	nodes, ok := s["nodes"].(map[string]any)
+	if !ok {
+		return nil, errors.New("Cannot find nodes") // nolint
+	}
 	n := ""
 	for k := range nodes {
 		if n != "" {
@@ -142,6 +551,19 @@ func (p ElasticsearchPlugin) FetchMetrics() (map[string]float64, error) {
 		n = k
 	}
 	node := nodes[n].(map[string]any)
+	if p.role != nil {
+		roles, _ := node["roles"].([]any)
+		*p.role = primaryRole(roles)
+	}
+	if p.attribute != nil && p.AttributePrefix != "" {
+		attrs, _ := node["attributes"].(map[string]any)
+		v, _ := attrs[p.AttributePrefix].(string)
+		if v == "" {
+			logger.Warningf("Node attribute %s is not found", p.AttributePrefix)
+			v = unknownAttribute
+		}
+		*p.attribute = invalidMetricChars.ReplaceAllString(v, "_")
+	}
 
 	for k, v := range metricPlace {
 		val, err := getFloatValue(node, v)
@@ -155,17 +577,204 @@ func (p ElasticsearchPlugin) FetchMetrics() (map[string]float64, error) {
 		stat[k] = val
 	}
 
+	// empty indices have no ratio
+	if count, deleted := stat["docs_count"], stat["docs_deleted"]; count+deleted > 0 {
+		stat["docs_deleted_ratio"] = deleted / (count + deleted) * 100
+	}
+
+	// default to the limit of the fielddata circuit breaker
+	limit := float64(p.FielddataLimitBytes)
+	if limit == 0 {
+		limit, _ = getFloatValue(node, []string{"breakers", "fielddata", "limit_size_in_bytes"})
+	}
+	if size, ok := stat["fielddata_size"]; ok && limit > 0 {
+		stat["fielddata_usage_percent"] = size / limit * 100
+	}
+
+	if open, limit := stat["open_file_descriptors"], stat["max_file_descriptors"]; limit > 0 {
+		stat["fd_usage_percent"] = open / limit * 100
+	}
+
+	rejected, err := sumThreadPools(node, "rejected")
+	if err != nil {
+		if !p.SuppressMissingError {
+			logger.Errorf("Failed to find '%s': %s", "thread_pool_rejected_total", err)
+		}
+	} else {
+		stat["thread_pool_rejected_total"] = rejected
+	}
+
+	if p.ScriptContexts {
+		// the keys of wildcard graphs are stored as they are
+		for name, v := range scriptContexts(node, "compilations") {
+			stat[p.keyPrefix()+".script.contexts."+name+".compilations"] = v
+		}
+	}
+
+	if p.AdaptiveSelection {
+		if ns, ok := maxAdaptiveSelection(node, "avg_response_time_ns"); ok {
+			stat["max_avg_response_time"] = ns / float64(time.Millisecond)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p ElasticsearchPlugin) fetchClusterHealth(ctx context.Context) (map[string]float64, error) {
+	var s map[string]any
+	if err := p.getJSON(ctx, "/_cluster/health", &s); err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for k, v := range clusterHealthPlace {
+		val, err := getFloatValue(s, v)
+		if err != nil {
+			if !p.SuppressMissingError {
+				logger.Errorf("Failed to find '%s': %s", k, err)
+			}
+			continue
+		}
+
+		stat[k] = val
+	}
+	return stat, nil
+}
+
+// shardStates are the states of shards in /_cat/shards.
+var shardStates = []string{"STARTED", "RELOCATING", "INITIALIZING", "UNASSIGNED"}
+
+func (p ElasticsearchPlugin) fetchShardStates(ctx context.Context) (map[string]float64, error) {
+	var shards []struct {
+		State string `json:"state"`
+	}
+	if err := p.getJSON(ctx, "/_cat/shards?h=state&format=json", &shards); err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for _, s := range shardStates {
+		stat["shards_"+strings.ToLower(s)] = 0
+	}
+	for _, s := range shards {
+		if !slices.Contains(shardStates, s.State) {
+			continue
+		}
+		stat["shards_"+strings.ToLower(s.State)]++
+	}
 	return stat, nil
 }
 
+var timeNow = time.Now
+
+func (p ElasticsearchPlugin) fetchLicense(ctx context.Context) (map[string]float64, error) {
+	var s map[string]any
+	if err := p.getJSON(ctx, "/_license", &s); err != nil {
+		var serr *statusError
+		if errors.As(err, &serr) && (serr.StatusCode == http.StatusNotFound || serr.StatusCode == http.StatusBadRequest) {
+			// OSS distributions don't have the license API.
+			logger.Infof("License API is not available: %s", err)
+			return map[string]float64{}, nil
+		}
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	expiry, err := getFloatValue(s, []string{"license", "expiry_date_in_millis"})
+	if err != nil {
+		// Basic licenses never expire, so they don't have the expiry date.
+		return stat, nil
+	}
+	now := float64(timeNow().UnixMilli())
+	stat["days_to_expiry"] = (expiry - now) / float64(24*time.Hour/time.Millisecond)
+	return stat, nil
+}
+
+// removedMetrics lists the metrics of metricPlace which no longer exist since each major version.
+var removedMetrics = map[int][]string{
+	7: {
+		"total_percolate", "total_suggest", "filter_cache_size", "evictions_filter_cache",
+		"threads_index", "threads_snapshot_data", "threads_bench", "threads_merge",
+		"threads_suggest", "threads_bulk", "threads_optimize", "threads_percolate",
+	},
+	8: {
+		"threads_listener",
+		// moved off-heap and always reported as 0
+		"segments_terms_size", "segments_stored_fields_size", "segments_norms_size",
+		"segments_points_size", "segments_doc_values_size",
+	},
+}
+
+// fetchMajorVersion returns the major version of Elasticsearch from the root endpoint.
+// OpenSearch, forked from v7.10, is reported as v7.
+func (p ElasticsearchPlugin) fetchMajorVersion(ctx context.Context) (int, error) {
+	var s struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := p.getJSON(ctx, "/", &s); err != nil {
+		return 0, err
+	}
+	if s.Version.Distribution == "opensearch" {
+		return 7, nil
+	}
+	major, _, _ := strings.Cut(s.Version.Number, ".")
+	v, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version %q", s.Version.Number)
+	}
+	return v, nil
+}
+
+// detectMajorVersion is fetchMajorVersion within -timeout, returning -1 if the version can't be detected
+// so that it is tried only once per run.
+func (p ElasticsearchPlugin) detectMajorVersion() int {
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	major, err := p.fetchMajorVersion(ctx)
+	if err != nil {
+		logger.Warningf("Failed to detect the version: %s", err)
+		return -1
+	}
+	return major
+}
+
+// removeMetrics removes the metrics which no longer exist in the major version from graphdef.
+func removeMetrics(graphdef map[string]mp.Graphs, major int) {
+	var removed []string
+	for v, names := range removedMetrics {
+		if major >= v {
+			removed = append(removed, names...)
+		}
+	}
+	for k, g := range graphdef {
+		g.Metrics = slices.DeleteFunc(slices.Clone(g.Metrics), func(m mp.Metrics) bool {
+			return slices.Contains(removed, m.Name)
+		})
+		if len(g.Metrics) == 0 {
+			delete(graphdef, k)
+			continue
+		}
+		graphdef[k] = g
+	}
+}
+
 // GraphDefinition interface for mackerelplugin
 func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
+	p.Prefix = p.keyPrefix()
 	var graphdef = map[string]mp.Graphs{
 		p.Prefix + ".http": {
 			Label: (p.LabelPrefix + " HTTP"),
 			Unit:  "integer",
 			Metrics: []mp.Metrics{
 				{Name: "http_opened", Label: "Opened", Diff: true},
+				{Name: "http_current_open", Label: "Current Open"},
 			},
 		},
 		p.Prefix + ".indices": {
@@ -185,6 +794,110 @@ func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "total_suggest", Label: "Suggest", Diff: true, Stacked: true},
 			},
 		},
+		p.Prefix + ".indices.flush_detail": {
+			Label: (p.LabelPrefix + " Indices Flush Detail"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "total_flush_periodic", Label: "Periodic", Diff: true},
+			},
+		},
+		p.Prefix + ".indices.get_detail": {
+			Label: (p.LabelPrefix + " Indices Get Detail"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "get_exists_total", Label: "Exists", Diff: true, Stacked: true},
+				{Name: "get_missing_total", Label: "Missing", Diff: true, Stacked: true},
+			},
+		},
+		p.Prefix + ".indices.get_latency": {
+			Label: (p.LabelPrefix + " Indices Get Latency"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "get_avg_time_ms", Label: "Average"},
+			},
+		},
+		p.Prefix + ".indices.warmer_latency": {
+			Label: (p.LabelPrefix + " Indices Warmer Latency"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "warmer_avg_time_ms", Label: "Average"},
+			},
+		},
+		p.Prefix + ".indices.search_current": {
+			Label: (p.LabelPrefix + " Indices Search Current"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "search_query_current", Label: "Query", Stacked: true},
+				{Name: "search_fetch_current", Label: "Fetch", Stacked: true},
+			},
+		},
+		p.Prefix + ".indices.merges_current": {
+			Label: (p.LabelPrefix + " Indices Merges Current"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "merges_current", Label: "Merges"},
+				{Name: "merges_current_docs", Label: "Docs"},
+			},
+		},
+		p.Prefix + ".indices.merges_current_size": {
+			Label: (p.LabelPrefix + " Indices Merges Current Size"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "merges_current_size", Label: "Size"},
+			},
+		},
+		p.Prefix + ".indices.indexing_current": {
+			Label: (p.LabelPrefix + " Indices Indexing Current"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "indexing_index_current", Label: "Index", Stacked: true},
+				{Name: "indexing_delete_current", Label: "Delete", Stacked: true},
+			},
+		},
+		p.Prefix + ".indices.indexing_throttle": {
+			Label: (p.LabelPrefix + " Indices Indexing Throttle Time"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "indexing_throttle_time", Label: "Throttle", Diff: true},
+			},
+		},
+		p.Prefix + ".indices.indexing_noop": {
+			Label: (p.LabelPrefix + " Indices Indexing Noop Updates"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "indexing_noop_update_total", Label: "Noop Updates", Diff: true},
+			},
+		},
+		p.Prefix + ".indices.recovery": {
+			Label: (p.LabelPrefix + " Indices Recovery Throttle Time"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "recovery_throttle_time", Label: "Throttle", Diff: true},
+			},
+		},
+		p.Prefix + ".indices.warmer_time": {
+			Label: (p.LabelPrefix + " Indices Warmer Time"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "total_warmer_time", Label: "Warmer", Diff: true},
+			},
+		},
+		p.Prefix + ".indices.refresh_external": {
+			Label: (p.LabelPrefix + " Indices External Refresh"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "refresh_external_total", Label: "Refresh", Diff: true},
+				{Name: "refresh_external_time", Label: "Time (ms)", Diff: true},
+			},
+		},
+		p.Prefix + ".indices.bulk": {
+			Label: (p.LabelPrefix + " Indices Bulk"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "bulk_total_operations", Label: "Operations", Diff: true},
+				{Name: "bulk_total_time", Label: "Time (ms)", Diff: true},
+			},
+		},
 		p.Prefix + ".indices.docs": {
 			Label: (p.LabelPrefix + " Indices Docs"),
 			Unit:  "integer",
@@ -193,6 +906,42 @@ func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "docs_deleted", Label: "Deleted", Stacked: true},
 			},
 		},
+		p.Prefix + ".indices.fielddata_usage": {
+			Label: (p.LabelPrefix + " Indices Fielddata Usage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "fielddata_usage_percent", Label: "Usage"},
+			},
+		},
+		p.Prefix + ".indices.store": {
+			Label: (p.LabelPrefix + " Indices Store"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "store_size", Label: "Size"},
+				{Name: "store_reserved", Label: "Reserved"},
+			},
+		},
+		p.Prefix + ".node.shards": {
+			Label: (p.LabelPrefix + " Node Shards"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "node_shard_count", Label: "Shards"},
+			},
+		},
+		p.Prefix + ".indices.completion": {
+			Label: (p.LabelPrefix + " Indices Completion"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "completion_size", Label: "Size"},
+			},
+		},
+		p.Prefix + ".indices.docs_ratio": {
+			Label: (p.LabelPrefix + " Indices Docs Ratio"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "docs_deleted_ratio", Label: "Deleted"},
+			},
+		},
 		p.Prefix + ".indices.memory_size": {
 			Label: (p.LabelPrefix + " Indices Memory Size"),
 			Unit:  "bytes",
@@ -205,6 +954,40 @@ func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "segments_fixed_bit_set_size", Label: "Lucene Segments Fixed Bit Set", Stacked: true},
 			},
 		},
+		p.Prefix + ".indices.segments_breakdown": {
+			Label: (p.LabelPrefix + " Indices Segments Breakdown"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "segments_terms_size", Label: "Terms", Stacked: true},
+				{Name: "segments_stored_fields_size", Label: "Stored Fields", Stacked: true},
+				{Name: "segments_norms_size", Label: "Norms", Stacked: true},
+				{Name: "segments_points_size", Label: "Points", Stacked: true},
+				{Name: "segments_doc_values_size", Label: "Doc Values", Stacked: true},
+			},
+		},
+		p.Prefix + ".indices.query_cache": {
+			Label: (p.LabelPrefix + " Indices Query Cache"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "query_cache_hit", Label: "Hit", Diff: true, Stacked: true},
+				{Name: "query_cache_miss", Label: "Miss", Diff: true, Stacked: true},
+			},
+		},
+		p.Prefix + ".indices.query_cache_detail": {
+			Label: (p.LabelPrefix + " Indices Query Cache Detail"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "query_cache_total_count", Label: "Lookups", Diff: true},
+				{Name: "query_cache_count", Label: "Entries"},
+			},
+		},
+		p.Prefix + ".indices.cache_ratio": {
+			Label: (p.LabelPrefix + " Indices Cache Hit Ratio"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "query_cache_hit_ratio", Label: "Query Cache"},
+			},
+		},
 		p.Prefix + ".indices.evictions": {
 			Label: (p.LabelPrefix + " Indices Evictions"),
 			Unit:  "integer",
@@ -221,6 +1004,31 @@ func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "heap_max", Label: "Max"},
 			},
 		},
+		p.Prefix + ".jvm.buffer_pools": {
+			Label: (p.LabelPrefix + " JVM Buffer Pools"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "jvm_direct_buffer_used", Label: "Direct"},
+				{Name: "jvm_mapped_buffer_used", Label: "Mapped"},
+			},
+		},
+		p.Prefix + ".jvm.pools": {
+			Label: (p.LabelPrefix + " JVM Memory Pools"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "jvm_pool_young_used", Label: "Young", Stacked: true},
+				{Name: "jvm_pool_survivor_used", Label: "Survivor", Stacked: true},
+				{Name: "jvm_pool_old_used", Label: "Old", Stacked: true},
+			},
+		},
+		p.Prefix + ".jvm.threads": {
+			Label: (p.LabelPrefix + " JVM Threads"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "jvm_threads_count", Label: "Count"},
+				{Name: "jvm_threads_peak", Label: "Peak"},
+			},
+		},
 		p.Prefix + ".thread_pool.threads": {
 			Label: (p.LabelPrefix + " Thread-Pool Threads"),
 			Unit:  "integer",
@@ -246,6 +1054,54 @@ func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "threads_listener", Label: "Listener", Stacked: true},
 			},
 		},
+		p.Prefix + ".thread_pool.active": {
+			Label: (p.LabelPrefix + " Thread-Pool Active"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "active_generic", Label: "Generic", Stacked: true},
+				{Name: "active_get", Label: "Get", Stacked: true},
+				{Name: "active_search", Label: "Search", Stacked: true},
+				{Name: "active_write", Label: "Write", Stacked: true},
+				{Name: "active_management", Label: "Management", Stacked: true},
+				{Name: "active_refresh", Label: "Refresh", Stacked: true},
+				{Name: "active_flush", Label: "Flush", Stacked: true},
+			},
+		},
+		p.Prefix + ".thread_pool.completed": {
+			Label: (p.LabelPrefix + " Thread-Pool Completed"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "completed_generic", Label: "Generic", Diff: true, Stacked: true},
+				{Name: "completed_get", Label: "Get", Diff: true, Stacked: true},
+				{Name: "completed_search", Label: "Search", Diff: true, Stacked: true},
+				{Name: "completed_write", Label: "Write", Diff: true, Stacked: true},
+				{Name: "completed_management", Label: "Management", Diff: true, Stacked: true},
+				{Name: "completed_refresh", Label: "Refresh", Diff: true, Stacked: true},
+				{Name: "completed_flush", Label: "Flush", Diff: true, Stacked: true},
+			},
+		},
+		p.Prefix + ".thread_pool.rejected_total": {
+			Label: (p.LabelPrefix + " Thread-Pool Rejected Total"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "thread_pool_rejected_total", Label: "Rejected", Diff: true},
+			},
+		},
+		p.Prefix + ".thread_pool.write": {
+			Label: (p.LabelPrefix + " Thread-Pool Write"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "write_completed", Label: "Completed", Diff: true},
+				{Name: "write_rejected", Label: "Rejected", Diff: true},
+			},
+		},
+		p.Prefix + ".thread_pool.rejection_ratio": {
+			Label: (p.LabelPrefix + " Thread-Pool Rejection Ratio"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "write_rejection_ratio", Label: "Write"},
+			},
+		},
 		p.Prefix + ".transport.count": {
 			Label: (p.LabelPrefix + " Transport Count"),
 			Unit:  "integer",
@@ -261,6 +1117,34 @@ func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "open_file_descriptors", Label: "Open File Descriptors"},
 			},
 		},
+		p.Prefix + ".process.fd": {
+			Label: (p.LabelPrefix + " Process File Descriptors"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "max_file_descriptors", Label: "Max"},
+			},
+		},
+		p.Prefix + ".process.fd_usage": {
+			Label: (p.LabelPrefix + " Process File Descriptors Usage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "fd_usage_percent", Label: "Usage"},
+			},
+		},
+		p.Prefix + ".process.cpu": {
+			Label: (p.LabelPrefix + " Process CPU Time"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "process_cpu_total", Label: "CPU", Diff: true},
+			},
+		},
+		p.Prefix + ".os.cgroup": {
+			Label: (p.LabelPrefix + " OS cgroup CPU Throttled Time (ns)"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "cgroup_cpu_throttled", Label: "Throttled", Diff: true},
+			},
+		},
 		p.Prefix + ".script": {
 			Label: (p.LabelPrefix + " Script"),
 			Unit:  "integer",
@@ -272,9 +1156,110 @@ func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
 		},
 	}
 
+	if p.License {
+		graphdef[p.Prefix+".license"] = mp.Graphs{
+			Label: (p.LabelPrefix + " License"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "days_to_expiry", Label: "Days to Expiry"},
+			},
+		}
+	}
+	if p.ClusterHealth {
+		graphdef[p.Prefix+".cluster.nodes"] = mp.Graphs{
+			Label: (p.LabelPrefix + " Cluster Nodes"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "number_of_nodes", Label: "Nodes"},
+				{Name: "number_of_data_nodes", Label: "Data Nodes"},
+			},
+		}
+		graphdef[p.Prefix+".cluster.shards"] = mp.Graphs{
+			Label: (p.LabelPrefix + " Cluster Shards"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "active_primary_shards", Label: "Active Primary"},
+				{Name: "active_shards", Label: "Active"},
+				{Name: "relocating_shards", Label: "Relocating"},
+				{Name: "initializing_shards", Label: "Initializing"},
+				{Name: "unassigned_shards", Label: "Unassigned"},
+				{Name: "delayed_unassigned_shards", Label: "Delayed Unassigned"},
+			},
+		}
+		graphdef[p.Prefix+".cluster"] = mp.Graphs{
+			Label: (p.LabelPrefix + " Cluster Active Shards"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "active_shards_percent", Label: "Active Shards"},
+			},
+		}
+	}
+	if p.ShardStates {
+		graphdef[p.Prefix+".shards.states"] = mp.Graphs{
+			Label: (p.LabelPrefix + " Shards States"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "shards_started", Label: "Started", Stacked: true},
+				{Name: "shards_relocating", Label: "Relocating", Stacked: true},
+				{Name: "shards_initializing", Label: "Initializing", Stacked: true},
+				{Name: "shards_unassigned", Label: "Unassigned", Stacked: true},
+			},
+		}
+	}
+	if p.ScriptContexts {
+		graphdef[p.Prefix+".script.contexts.#"] = mp.Graphs{
+			Label: (p.LabelPrefix + " Script Compilations by Context"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "compilations", Label: "%1", Diff: true},
+			},
+		}
+	}
+	if p.AdaptiveSelection {
+		graphdef[p.Prefix+".adaptive_selection"] = mp.Graphs{
+			Label: (p.LabelPrefix + " Adaptive Selection Response Time"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "max_avg_response_time", Label: "Max Avg Response Time"},
+			},
+		}
+	}
+	if p.EmitScrapeDuration {
+		graphdef[p.Prefix+".plugin"] = mp.Graphs{
+			Label: (p.LabelPrefix + " Plugin Scrape Duration"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "scrape_duration_ms", Label: "Duration"},
+			},
+		}
+	}
+
+	// the version is detected here rather than in FetchMetrics, since the definitions are output
+	// without fetching in the meta mode
+	if p.major != nil && *p.major == 0 {
+		*p.major = p.detectMajorVersion()
+	}
+	// keep all the metrics if the version is unknown, so that the graphs are never lost
+	if p.major != nil && *p.major > 0 {
+		removeMetrics(graphdef, *p.major)
+	}
+
+	if p.Interval > 0 {
+		plugincommon.ScaleDiffs(graphdef, p.Interval)
+	}
+
 	return graphdef
 }
 
+// decodeBase64Flag decodes the value of the base64 encoded flag name.
+func decodeBase64Flag(name, s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("-%s is not valid base64: %w", name, err)
+	}
+	return string(b), nil
+}
+
 // Do the plugin
 func Do() {
 	optScheme := flag.String("scheme", "http", "Scheme")
@@ -284,11 +1269,50 @@ func Do() {
 	optLabelPrefix := flag.String("metric-label-prefix", "", "Metric Label prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optInsecure := flag.Bool("insecure", false, "Skip TLS certificate verification")
+	flag.BoolVar(optInsecure, "insecure-skip-verify", false, "Alias of -insecure")
+	optCAFile := flag.String("ca-file", "", "Verify the server with the CA certificates in the PEM `file` instead of the system pool")
+	optTLSMinVersion := flag.String("tls-min-version", "1.2", "Minimum TLS `version` with -scheme=https (1.0, 1.1, 1.2 or 1.3)")
 	optUser := flag.String("user", "", "Basic auth user")
 	optPassword := flag.String("password", "", "Basic auth password")
+	optUserBase64 := flag.String("user-base64", "", "Base64 encoded basic auth user (overrides -user)")
+	optPasswordBase64 := flag.String("password-base64", "", "Base64 encoded basic auth password (overrides -password)")
+	optHostHeader := flag.String("host-header", "", "Override the Host header, e.g. to scrape a virtual-hosted cluster through a forwarded port")
+	optNetrc := flag.String("netrc", os.Getenv("NETRC"), "Read basic auth credentials for the host from the netrc `file` unless -user and -password are given")
 	optSuppressMissingError := flag.Bool("suppress-missing-error", false, "Suppress ERROR for missing values")
+	var optHeader plugincommon.HeaderFlag
+	flag.Var(&optHeader, "header", "Set http header (e.g. \"X-Api-Key: secret\"), can be specified multiple times")
+	optLicense := flag.Bool("license", false, "Also collect days to expiry of the license")
+	optStrict := flag.Bool("strict", false, "Exit with an error if the tempfile is not writable or any of -required-metrics is missing")
+	optRequiredMetrics := flag.String("required-metrics", "", "Comma separated `names` of the metrics such as heap_used which -strict requires")
+	optQuiet := flag.Bool("quiet", false, "Suppress all non-fatal log messages")
+	optEmitScrapeDuration := flag.Bool("emit-scrape-duration", false, "Also emit the time taken to fetch the metrics")
+	optClusterHealth := flag.Bool("cluster-health", false, "Also collect metrics from the cluster health API")
+	optShardStates := flag.Bool("shard-states", false, "Also collect the number of shards in each state from the cat shards API")
+	optFielddataLimitBytes := flag.Uint64("fielddata-limit-bytes", 0, "Limit of fielddata in bytes to calculate its usage (default: the limit of the fielddata circuit breaker)")
+	optAdaptiveSelection := flag.Bool("adaptive-selection", false, "Also emit the max of the average response times to the nodes from the adaptive replica selection stats")
+	optScriptContexts := flag.Bool("script-contexts", false, "Also emit the script compilations for each script context")
+	optAttributePrefix := flag.String("attribute-prefix", "", "Prepend the value of the node `attribute` such as zone to the metric keys, e.g. elasticsearch.zone-a.jvm.heap.used")
+	optRolePrefix := flag.Bool("role-prefix", false, "Prepend the primary role of the node to the metric keys, e.g. elasticsearch.data.jvm.heap.used")
+	optTimeout := flag.Duration("timeout", 10*time.Second, "Timeout for all the requests of a run in total (0 disables the timeout)")
+	optInterval := flag.Duration("interval", 0, "Report the metrics taking differences per this `duration` instead of per minute, e.g. 1s for per second")
+	optFormat := flag.String("format", "mackerel", "Output `format` (mackerel or prometheus)")
+	optErrorFormat := flag.String("error-format", errorformat.Text, "`format` of the error written to stderr when the metrics can't be fetched (text or json)")
 	flag.Parse()
 
+	if *optFormat != "mackerel" && *optFormat != "prometheus" {
+		log.Fatalf("unknown format: %s", *optFormat)
+	}
+	if *optErrorFormat != errorformat.Text && *optErrorFormat != errorformat.JSON {
+		log.Fatalf("unknown error format: %s", *optErrorFormat)
+	}
+	if *optRequiredMetrics != "" && !*optStrict {
+		log.Fatalln("-required-metrics can't be used without -strict")
+	}
+
+	if *optQuiet {
+		logging.SetLogLevel(logging.CRITICAL)
+	}
+
 	var elasticsearch ElasticsearchPlugin
 	elasticsearch.URI = fmt.Sprintf("%s://%s:%s", *optScheme, *optHost, *optPort)
 	elasticsearch.Prefix = *optPrefix
@@ -298,15 +1322,93 @@ func Do() {
 		elasticsearch.LabelPrefix = *optLabelPrefix
 	}
 	elasticsearch.Insecure = *optInsecure
+	elasticsearch.CAFile = *optCAFile
+	tlsMinVersion, err := plugincommon.ParseTLSVersion(*optTLSMinVersion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	elasticsearch.TLSMinVersion = tlsMinVersion
+	elasticsearch.FielddataLimitBytes = *optFielddataLimitBytes
 	elasticsearch.User = *optUser
 	elasticsearch.Password = *optPassword
+	if *optUserBase64 != "" {
+		user, err := decodeBase64Flag("user-base64", *optUserBase64)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		elasticsearch.User = user
+	}
+	if *optPasswordBase64 != "" {
+		password, err := decodeBase64Flag("password-base64", *optPasswordBase64)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		elasticsearch.Password = password
+	}
+	if *optNetrc != "" && elasticsearch.User == "" && elasticsearch.Password == "" {
+		user, password, ok, err := readNetrc(*optNetrc, *optHost)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if ok {
+			elasticsearch.User = user
+			elasticsearch.Password = password
+		}
+	}
 	elasticsearch.SuppressMissingError = *optSuppressMissingError
+	elasticsearch.ClusterHealth = *optClusterHealth
+	elasticsearch.ShardStates = *optShardStates
+	elasticsearch.License = *optLicense
+	elasticsearch.Header = optHeader
+	elasticsearch.HostHeader = *optHostHeader
+	elasticsearch.EmitScrapeDuration = *optEmitScrapeDuration
+	elasticsearch.AdaptiveSelection = *optAdaptiveSelection
+	elasticsearch.ScriptContexts = *optScriptContexts
+	elasticsearch.RolePrefix = *optRolePrefix
+	elasticsearch.Strict = *optStrict
+	if *optRequiredMetrics != "" {
+		for _, name := range strings.Split(*optRequiredMetrics, ",") {
+			elasticsearch.RequiredMetrics = append(elasticsearch.RequiredMetrics, strings.TrimSpace(name))
+		}
+	}
+	elasticsearch.Interval = *optInterval
+	elasticsearch.Timeout = *optTimeout
+	elasticsearch.role = new(string)
+	elasticsearch.major = new(int)
+	elasticsearch.AttributePrefix = *optAttributePrefix
+	if elasticsearch.AttributePrefix != "" {
+		elasticsearch.attribute = new(string)
+	}
 
-	helper := mp.NewMackerelPlugin(elasticsearch)
-	if *optTempfile != "" {
-		helper.Tempfile = *optTempfile
-	} else {
-		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-elasticsearch-%s-%s", *optHost, *optPort))
+	tempfile := *optTempfile
+	if tempfile == "" {
+		tempfile = filepath.Join(pluginutil.PluginWorkDir(), fmt.Sprintf("mackerel-plugin-elasticsearch-%s-%s", *optHost, *optPort))
+	}
+	// Ratios over the interval are derived from the counters of the previous run.
+	elasticsearch.StateFile = tempfile + ".state"
+
+	if *optFormat == "prometheus" {
+		stat, err := elasticsearch.FetchMetrics()
+		if err != nil {
+			fetchFailed(*optErrorFormat, elasticsearch.URI, err)
+		}
+		if err := writePrometheus(os.Stdout, elasticsearch.GraphDefinition(), stat); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	var plugin mp.Plugin = elasticsearch
+	if *optErrorFormat == errorformat.JSON {
+		plugin = jsonErrorPlugin{elasticsearch}
+	}
+	helper := mp.NewMackerelPlugin(plugin)
+	helper.Tempfile = tempfile
+	if err := plugincommon.CheckTempfile(helper.Tempfile); err != nil {
+		if *optStrict {
+			log.Fatalf("tempfile is not writable: %s", err)
+		}
+		logger.Warningf("tempfile is not writable, so metrics taking differences will be wrong: %s", err)
 	}
 
 	helper.Run()
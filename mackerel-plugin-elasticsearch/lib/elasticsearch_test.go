@@ -1,12 +1,20 @@
 package mpelasticsearch
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
+	mp "github.com/mackerelio/go-mackerel-plugin"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,6 +27,21 @@ var testHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request)
 	fmt.Fprint(w, string(json))
 })
 
+func newTestServer(t *testing.T, files map[string]string) *httptest.Server {
+	mux := http.NewServeMux()
+	for path, file := range files {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			w.Write(b)
+		})
+	}
+	return httptest.NewServer(mux)
+}
+
 func TestGraphDefinition(t *testing.T) {
 	elasticsearch := ElasticsearchPlugin{
 		Prefix:      "elasticsearch",
@@ -34,6 +57,108 @@ func TestGraphDefinition(t *testing.T) {
 	assert.EqualValues(t, "compilation_limit_triggered", graphdef["elasticsearch.script"].Metrics[2].Name)
 }
 
+func TestGraphDefinition_Interval(t *testing.T) {
+	elasticsearch := ElasticsearchPlugin{
+		Prefix:      "elasticsearch",
+		LabelPrefix: "Elasticsearch",
+		Interval:    time.Second,
+	}
+	graphdef := elasticsearch.GraphDefinition()
+
+	assert.InDelta(t, 1.0/60, graphdef["elasticsearch.http"].Metrics[0].Scale, 1e-9)
+	assert.Zero(t, graphdef["elasticsearch.jvm.heap"].Metrics[0].Scale)
+}
+
+func TestGraphDefinition_Version(t *testing.T) {
+	tests := []struct {
+		body      string
+		percolate bool
+		listener  bool
+	}{
+		{`{"version":{"number":"6.8.23"}}`, true, true},
+		{`{"version":{"number":"7.17.0"}}`, false, true},
+		{`{"version":{"number":"8.11.1"}}`, false, false},
+		{`{"version":{"distribution":"opensearch","number":"2.11.0"}}`, false, true},
+		{`{}`, true, true},
+	}
+	for _, tt := range tests {
+		var requests int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.URL.Path == "/" {
+				fmt.Fprint(w, tt.body)
+				return
+			}
+			testHandler(w, r)
+		}))
+		elasticsearch := ElasticsearchPlugin{
+			URI:         ts.URL,
+			Prefix:      "elasticsearch",
+			LabelPrefix: "Elasticsearch",
+			major:       new(int),
+		}
+		// the version is detected once per run, even if it can't be
+		graphdef := elasticsearch.GraphDefinition()
+		elasticsearch.GraphDefinition()
+		assert.Equal(t, 1, requests, tt.body)
+		ts.Close()
+
+		names := func(key string) []string {
+			var names []string
+			for _, m := range graphdef[key].Metrics {
+				names = append(names, m.Name)
+			}
+			return names
+		}
+		if tt.percolate {
+			assert.Contains(t, names("elasticsearch.indices"), "total_percolate", tt.body)
+		} else {
+			assert.NotContains(t, names("elasticsearch.indices"), "total_percolate", tt.body)
+			assert.NotContains(t, names("elasticsearch.indices.memory_size"), "filter_cache_size", tt.body)
+			assert.NotContains(t, names("elasticsearch.thread_pool.threads"), "threads_bulk", tt.body)
+		}
+		assert.Equal(t, tt.listener, slices.Contains(names("elasticsearch.thread_pool.threads"), "threads_listener"), tt.body)
+		assert.Equal(t, tt.listener, slices.Contains(names("elasticsearch.indices.segments_breakdown"), "segments_terms_size"), tt.body)
+		assert.Contains(t, names("elasticsearch.indices"), "total_search_query", tt.body)
+	}
+}
+
+func TestOutputDefinitions_Version(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			t.Errorf("unexpected request to %s in the meta mode", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"version":{"number":"7.17.0"}}`)
+	}))
+	defer ts.Close()
+	helper := mp.NewMackerelPlugin(ElasticsearchPlugin{
+		URI:         ts.URL,
+		Prefix:      "elasticsearch",
+		LabelPrefix: "Elasticsearch",
+		Timeout:     time.Second,
+		major:       new(int),
+	})
+
+	// the definitions are written to os.Stdout
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdout := os.Stdout
+	os.Stdout = f
+	helper.OutputDefinitions()
+	os.Stdout = stdout
+	out, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Contains(t, string(out), `"total_search_query"`)
+	assert.NotContains(t, string(out), `"total_percolate"`)
+	assert.NotContains(t, string(out), `"total_suggest"`)
+}
+
 func TestFetchMetrics(t *testing.T) {
 	ts := httptest.NewServer(testHandler)
 	defer ts.Close()
@@ -46,10 +171,646 @@ func TestFetchMetrics(t *testing.T) {
 	}
 
 	assert.EqualValues(t, 37, stat["http_opened"])
+	assert.EqualValues(t, 1, stat["http_current_open"])
 	assert.EqualValues(t, 8, stat["threads_generic"])
 	assert.EqualValues(t, 13, stat["threads_search"])
 	assert.EqualValues(t, 0, stat["threads_fetch_shard_started"])
 	assert.EqualValues(t, 0, stat["threads_fetch_shard_store"])
 	assert.EqualValues(t, 331, stat["open_file_descriptors"])
+	assert.EqualValues(t, 1048576, stat["max_file_descriptors"])
+	assert.InDelta(t, 331.0/1048576*100, stat["fd_usage_percent"], 1e-9)
+	assert.EqualValues(t, 2821810, stat["process_cpu_total"])
+	assert.EqualValues(t, 3942645760, stat["jvm_pool_young_used"])
+	assert.EqualValues(t, 651624, stat["jvm_pool_survivor_used"])
+	assert.EqualValues(t, 133770752, stat["jvm_pool_old_used"])
+	assert.EqualValues(t, 2, stat["indexing_index_current"])
+	assert.EqualValues(t, 4, stat["indexing_noop_update_total"])
+	assert.EqualValues(t, 95, stat["refresh_external_total"])
+	assert.EqualValues(t, 154, stat["bulk_total_operations"])
+	assert.EqualValues(t, 2213, stat["bulk_total_time"])
+	assert.EqualValues(t, 1, stat["active_management"])
+	assert.EqualValues(t, 12, stat["query_cache_total_count"])
+	assert.EqualValues(t, 3, stat["query_cache_count"])
+	assert.EqualValues(t, 36352, stat["segments_terms_size"])
+	assert.EqualValues(t, 8936, stat["segments_doc_values_size"])
+	assert.EqualValues(t, 12170, stat["completed_search"])
+	assert.EqualValues(t, 2007, stat["completed_write"])
+	assert.EqualValues(t, 34545, stat["refresh_external_time"])
+	assert.EqualValues(t, 0, stat["indexing_delete_current"])
+	assert.Contains(t, stat, "indexing_delete_current")
 	assert.EqualValues(t, 1, stat["compilations"])
+	assert.EqualValues(t, 7, stat["total_warmer_time"])
+	assert.Contains(t, stat, "indexing_throttle_time")
+	assert.EqualValues(t, 83, stat["jvm_threads_count"])
+	assert.EqualValues(t, 86, stat["jvm_threads_peak"])
+	assert.EqualValues(t, 9803382, stat["jvm_direct_buffer_used"])
+	assert.EqualValues(t, 86246416, stat["jvm_mapped_buffer_used"])
+	assert.Contains(t, stat, "total_flush_periodic")
+	assert.Contains(t, stat, "docs_deleted_ratio")
+	assert.Contains(t, stat, "cgroup_cpu_throttled")
+	assert.Contains(t, stat, "recovery_throttle_time")
+	assert.Contains(t, stat, "fielddata_usage_percent")
+	assert.EqualValues(t, 88314702, stat["store_size"])
+	assert.Contains(t, stat, "store_reserved")
+	assert.EqualValues(t, 0, stat["docs_deleted_ratio"])
+	assert.Contains(t, stat, "get_exists_total")
+	assert.Contains(t, stat, "get_missing_total")
+	assert.Contains(t, stat, "thread_pool_rejected_total")
+	assert.EqualValues(t, 0, stat["search_query_current"])
+	assert.EqualValues(t, 0, stat["search_fetch_current"])
+	assert.EqualValues(t, 0, stat["completion_size"])
+	assert.EqualValues(t, 8, stat["node_shard_count"])
+	assert.EqualValues(t, 0, stat["merges_current"])
+	assert.EqualValues(t, 0, stat["merges_current_docs"])
+	assert.EqualValues(t, 0, stat["merges_current_size"])
+}
+
+func TestFetchMetrics_RolePrefix(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{
+		URI:         ts.URL,
+		Prefix:      "elasticsearch",
+		LabelPrefix: "Elasticsearch",
+		RolePrefix:  true,
+		role:        new(string),
+	}
+	// definitions are output before fetching
+	graphdef := elasticsearch.GraphDefinition()
+	assert.Contains(t, graphdef, "elasticsearch.#.jvm.heap")
+
+	if _, err := elasticsearch.FetchMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	graphdef = elasticsearch.GraphDefinition()
+	assert.Contains(t, graphdef, "elasticsearch.master.jvm.heap")
+	assert.NotContains(t, graphdef, "elasticsearch.jvm.heap")
+}
+
+func TestFetchMetrics_AttributePrefix(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	tests := []struct {
+		attr   string
+		role   bool
+		before string
+		want   string
+	}{
+		{"zone", false, "elasticsearch.#.jvm.heap", "elasticsearch.zone-a.jvm.heap"},
+		{"zone", true, "elasticsearch.#.#.jvm.heap", "elasticsearch.zone-a.master.jvm.heap"},
+		{"rack", false, "elasticsearch.#.jvm.heap", "elasticsearch.unknown.jvm.heap"},
+	}
+	for _, tt := range tests {
+		elasticsearch := ElasticsearchPlugin{
+			URI:             ts.URL,
+			Prefix:          "elasticsearch",
+			LabelPrefix:     "Elasticsearch",
+			AttributePrefix: tt.attr,
+			RolePrefix:      tt.role,
+			role:            new(string),
+			attribute:       new(string),
+		}
+		// definitions are output before fetching
+		assert.Contains(t, elasticsearch.GraphDefinition(), tt.before, tt.want)
+
+		if _, err := elasticsearch.FetchMetrics(); err != nil {
+			t.Fatal(err)
+		}
+		graphdef := elasticsearch.GraphDefinition()
+		assert.Contains(t, graphdef, tt.want)
+		assert.NotContains(t, graphdef, "elasticsearch.jvm.heap", tt.want)
+	}
+}
+
+func TestFetchMetrics_AttributePrefixUnset(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{
+		URI:       ts.URL,
+		Prefix:    "elasticsearch",
+		role:      new(string),
+		attribute: new(string),
+	}
+	if _, err := elasticsearch.FetchMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	// the attribute is not looked up, so it is neither warned about nor replaced with "unknown"
+	assert.Equal(t, "", *elasticsearch.attribute)
+	assert.Contains(t, elasticsearch.GraphDefinition(), "elasticsearch.jvm.heap")
+}
+
+func TestPrimaryRole(t *testing.T) {
+	assert.Equal(t, "master", primaryRole([]any{"data", "master", "ingest"}))
+	assert.Equal(t, "data", primaryRole([]any{"ingest", "data_hot", "data_content"}))
+	assert.Equal(t, "ingest", primaryRole([]any{"ingest", "remote_cluster_client"}))
+	assert.Equal(t, "coordinating", primaryRole([]any{}))
+	assert.Equal(t, "coordinating", primaryRole(nil))
+}
+
+func TestFetchMetrics_QueryCacheHitRatio(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	state := filepath.Join(t.TempDir(), "state")
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, StateFile: state}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, stat, "query_cache_hit")
+	assert.NotContains(t, stat, "query_cache_hit_ratio", "first run has no previous values")
+
+	// pretend the previous run saw 3 hits and 1 miss less than stat.json
+	saveState(state, map[string]float64{"query_cache_hit": -3, "query_cache_miss": -1})
+	stat, err = elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 75, stat["query_cache_hit_ratio"])
+}
+
+func TestFetchMetrics_GetAvgTime(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	state := filepath.Join(t.TempDir(), "state")
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, StateFile: state}
+	// pretend the previous run saw no gets since stat.json
+	saveState(state, map[string]float64{"total_get": 0, "total_get_time": 0})
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, stat, "get_avg_time_ms")
+
+	// pretend the previous run saw 4 gets taking 10ms less than stat.json
+	saveState(state, map[string]float64{"total_get": -4, "total_get_time": -10})
+	stat, err = elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 2.5, stat["get_avg_time_ms"])
+}
+
+func TestFetchMetrics_WarmerAvgTime(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	state := filepath.Join(t.TempDir(), "state")
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, StateFile: state}
+	// pretend the previous run saw no warmers since stat.json
+	saveState(state, map[string]float64{"total_warmer": 85, "total_warmer_time": 7})
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, stat, "warmer_avg_time_ms")
+
+	// pretend the previous run saw 5 warmers taking 2ms less than stat.json
+	saveState(state, map[string]float64{"total_warmer": 80, "total_warmer_time": 5})
+	stat, err = elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 0.4, stat["warmer_avg_time_ms"])
+}
+
+func TestFetchMetrics_WriteRejectionRatio(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	state := filepath.Join(t.TempDir(), "state")
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, StateFile: state}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 2007, stat["write_completed"])
+	assert.EqualValues(t, 0, stat["write_rejected"])
+	assert.NotContains(t, stat, "write_rejection_ratio", "first run has no previous values")
+
+	// pretend the previous run saw 1 rejection and 3 completions less than stat.json
+	saveState(state, map[string]float64{"write_rejected": -1, "write_completed": 2004})
+	stat, err = elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 25, stat["write_rejection_ratio"])
+}
+
+func TestFetchMetrics_Strict(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{
+		URI:             ts.URL,
+		RequiredMetrics: []string{"heap_used", "total_percolate", "threads_bench"},
+	}
+	_, err := elasticsearch.FetchMetrics()
+	assert.NoError(t, err, "missing metrics are allowed without Strict")
+
+	elasticsearch.Strict = true
+	_, err = elasticsearch.FetchMetrics()
+	assert.EqualError(t, err, "required metrics are missing: total_percolate, threads_bench")
+
+	elasticsearch.RequiredMetrics = []string{"heap_used", "docs_count"}
+	stat, err := elasticsearch.FetchMetrics()
+	assert.NoError(t, err)
+	assert.Contains(t, stat, "heap_used")
+}
+
+func TestFetchMetrics_StrictDerived(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	state := filepath.Join(t.TempDir(), "state")
+	elasticsearch := ElasticsearchPlugin{
+		URI:             ts.URL,
+		StateFile:       state,
+		Strict:          true,
+		RequiredMetrics: []string{"query_cache_hit_ratio"},
+	}
+	_, err := elasticsearch.FetchMetrics()
+	assert.EqualError(t, err, "required metrics are missing: query_cache_hit_ratio", "first run has no previous values")
+
+	// the derived metrics are required as well as the others
+	saveState(state, map[string]float64{"query_cache_hit": -3, "query_cache_miss": -1})
+	stat, err := elasticsearch.FetchMetrics()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 75, stat["query_cache_hit_ratio"])
+}
+
+func TestFetchMetrics_EmitScrapeDuration(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, Prefix: "elasticsearch", EmitScrapeDuration: true}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, stat, "scrape_duration_ms")
+	assert.Contains(t, elasticsearch.GraphDefinition(), "elasticsearch.plugin")
+}
+
+func TestFetchMetrics_StateNodeStatsFailed(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"/_cluster/health": "./cluster_health.json",
+	})
+	defer ts.Close()
+
+	state := filepath.Join(t.TempDir(), "state")
+	saveState(state, map[string]float64{"query_cache_hit": 3, "query_cache_miss": 1})
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, ClusterHealth: true, StateFile: state}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 3, stat["number_of_nodes"])
+	assert.Equal(t, map[string]float64{"query_cache_hit": 3, "query_cache_miss": 1}, loadState(state), "the state is kept")
+}
+
+func TestFetchMetrics_ClusterHealth(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"/_nodes/_local/stats": "./stat.json",
+		"/_cluster/health":     "./cluster_health.json",
+	})
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, ClusterHealth: true}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, 37, stat["http_opened"])
+	assert.EqualValues(t, 3, stat["number_of_nodes"])
+	assert.EqualValues(t, 2, stat["number_of_data_nodes"])
+	assert.EqualValues(t, 1, stat["unassigned_shards"])
+	assert.EqualValues(t, 0, stat["delayed_unassigned_shards"])
+	assert.EqualValues(t, 87.5, stat["active_shards_percent"])
+}
+
+func TestFetchMetrics_ShardStates(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"/_nodes/_local/stats": "./stat.json",
+		"/_cat/shards":         "./cat_shards.json",
+	})
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, ShardStates: true}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, 3, stat["shards_started"])
+	assert.EqualValues(t, 1, stat["shards_relocating"])
+	assert.EqualValues(t, 1, stat["shards_initializing"])
+	assert.EqualValues(t, 2, stat["shards_unassigned"])
+}
+
+func TestFetchMetrics_PartialFailure(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"/_nodes/_local/stats": "./stat.json",
+	})
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, ClusterHealth: true}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, 37, stat["http_opened"])
+	assert.NotContains(t, stat, "number_of_nodes")
+}
+
+func TestFetchMetrics_Timeout(t *testing.T) {
+	stats, err := os.ReadFile("./stat.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_cluster/health" {
+			// slow enough to use up the deadline shared with the node stats
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Write(stats)
+	}))
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, ClusterHealth: true, License: true, Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+	assert.EqualValues(t, 37, stat["http_opened"], "metrics fetched before the deadline should be reported")
+	assert.NotContains(t, stat, "number_of_nodes")
+	assert.NotContains(t, stat, "days_to_expiry")
+}
+
+func TestFetchMetrics_License(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"/_nodes/_local/stats": "./stat.json",
+		"/_license":            "./license.json",
+	})
+	defer ts.Close()
+
+	timeNow = func() time.Time {
+		return time.UnixMilli(1699432922467).Add(-36 * time.Hour)
+	}
+	defer func() { timeNow = time.Now }()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, License: true}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 1.5, stat["days_to_expiry"])
+}
+
+func TestFetchMetrics_LicenseNotAvailable(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"/_nodes/_local/stats": "./stat.json",
+	})
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, License: true}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, stat, "days_to_expiry")
+}
+
+func TestFetchMetrics_Header(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Gateway-Key") != "secret" || r.Host != "es.example.com" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		testHandler(w, r)
+	}))
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{
+		URI:    ts.URL,
+		Header: []string{"X-Api-Gateway-Key: secret", "Host: es.example.com"},
+	}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 37, stat["http_opened"])
+}
+
+func TestFetchMetrics_HostHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "es.example.com" {
+			http.Error(w, "unknown host", http.StatusNotFound)
+			return
+		}
+		testHandler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, Header: []string{"Host: other.example.com"}, HostHeader: "es.example.com"}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 37, stat["http_opened"])
+}
+
+func TestFetchMetrics_CAFile(t *testing.T) {
+	ts := httptest.NewTLSServer(testHandler)
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL}
+	_, err := elasticsearch.FetchMetrics()
+	assert.NotNil(t, err, "the certificate of the test server isn't trusted by the system pool")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	elasticsearch.CAFile = caFile
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 37, stat["http_opened"])
+}
+
+func TestTLSConfig_MinVersion(t *testing.T) {
+	elasticsearch := ElasticsearchPlugin{TLSMinVersion: tls.VersionTLS13}
+	c, err := elasticsearch.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, tls.VersionTLS13, c.MinVersion)
+}
+
+func TestHostname(t *testing.T) {
+	assert.Equal(t, "es.example.com", hostname("es.example.com"))
+	assert.Equal(t, "es.example.com", hostname("es.example.com:9200"))
+	assert.Equal(t, "::1", hostname("[::1]:9200"))
+}
+
+func TestFetchMetrics_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	var elasticsearch ElasticsearchPlugin
+	elasticsearch.URI = ts.URL
+	_, err := elasticsearch.FetchMetrics()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "/_nodes/_local/stats")
+	}
+}
+
+func TestSumThreadPools(t *testing.T) {
+	node := map[string]any{
+		"thread_pool": map[string]any{
+			"search": map[string]any{"threads": 13.0, "rejected": 2.0},
+			"write":  map[string]any{"threads": 8.0, "rejected": 5.0},
+			"get":    map[string]any{"threads": 0.0},
+		},
+	}
+	rejected, err := sumThreadPools(node, "rejected")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 7, rejected)
+
+	_, err = sumThreadPools(map[string]any{}, "rejected")
+	assert.NotNil(t, err)
+}
+
+func TestFetchMetrics_AdaptiveSelection(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, AdaptiveSelection: true}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.InDelta(t, 3.408671, stat["max_avg_response_time"], 1e-9)
+}
+
+func TestMaxAdaptiveSelection(t *testing.T) {
+	node := map[string]any{
+		"adaptive_selection": map[string]any{
+			"a": map[string]any{"avg_response_time_ns": 3000000.0},
+			"b": map[string]any{"avg_response_time_ns": 9000000.0},
+			"c": map[string]any{"outgoing_searches": 0.0},
+		},
+	}
+	v, ok := maxAdaptiveSelection(node, "avg_response_time_ns")
+	assert.True(t, ok)
+	assert.EqualValues(t, 9000000, v)
+
+	_, ok = maxAdaptiveSelection(map[string]any{"adaptive_selection": map[string]any{}}, "avg_response_time_ns")
+	assert.False(t, ok)
+}
+
+func TestScriptContexts(t *testing.T) {
+	node := map[string]any{
+		"script": map[string]any{
+			"compilations": 5.0,
+			"contexts": []any{
+				map[string]any{"context": "aggs", "compilations": 3.0},
+				map[string]any{"context": "painless_test", "compilations": 2.0},
+			},
+		},
+	}
+	assert.Equal(t, map[string]float64{"aggs": 3, "painless_test": 2}, scriptContexts(node, "compilations"))
+
+	node = map[string]any{
+		"script_cache": map[string]any{
+			"contexts": []any{
+				map[string]any{"context": "ingest", "compilations": 1.0},
+			},
+		},
+	}
+	assert.Equal(t, map[string]float64{"ingest": 1}, scriptContexts(node, "compilations"))
+	assert.Empty(t, scriptContexts(map[string]any{}, "compilations"))
+}
+
+func TestFetchMetrics_ScriptContexts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"nodes": {"n": {"script": {"contexts": [{"context": "aggs", "compilations": 3}]}}}}`)
+	}))
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, Prefix: "elasticsearch", ScriptContexts: true, SuppressMissingError: true}
+	stat, err := elasticsearch.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 3, stat["elasticsearch.script.contexts.aggs.compilations"])
+	assert.Contains(t, elasticsearch.GraphDefinition(), "elasticsearch.script.contexts.#")
+}
+
+func TestDecodeBase64Flag(t *testing.T) {
+	s, err := decodeBase64Flag("password-base64", "cGEkJHdgb3Jk")
+	assert.Nil(t, err)
+	assert.Equal(t, "pa$$w`ord", s)
+
+	_, err = decodeBase64Flag("password-base64", "not base64!")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "-password-base64")
+	}
+}
+
+func TestGetJSON_RequestPhase(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + l.Addr().String()
+	l.Close()
+
+	tlsServer := httptest.NewTLSServer(testHandler)
+	defer tlsServer.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		uri   string
+		phase string
+	}{
+		{"http://nonexistent.invalid:9200", "DNS lookup"},
+		{refused, "connect"},
+		{tlsServer.URL, "TLS handshake"},
+		{slow.URL, "read"},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		elasticsearch := ElasticsearchPlugin{URI: tt.uri}
+		var v any
+		err := elasticsearch.getJSON(ctx, "/_nodes/_local/stats", &v)
+		cancel()
+
+		var rerr *requestError
+		if assert.ErrorAs(t, err, &rerr, tt.uri) {
+			assert.Equal(t, tt.phase, rerr.Phase, err.Error())
+			assert.Contains(t, err.Error(), tt.phase+" failed: ", tt.uri)
+		}
+	}
 }
@@ -0,0 +1,24 @@
+package mpelasticsearch
+
+import (
+	"github.com/mackerelio/mackerel-agent-plugins/internal/errorformat"
+)
+
+// fetchFailed writes err to fetch the metrics from target to stderr in the format and exits.
+func fetchFailed(format, target string, err error) {
+	errorformat.Exit(format, "elasticsearch", target, err)
+}
+
+// jsonErrorPlugin writes the failure to fetch the metrics in JSON instead of the plain text of the helper.
+type jsonErrorPlugin struct {
+	ElasticsearchPlugin
+}
+
+// FetchMetrics interface for mackerelplugin
+func (p jsonErrorPlugin) FetchMetrics() (map[string]float64, error) {
+	stat, err := p.ElasticsearchPlugin.FetchMetrics()
+	if err != nil {
+		fetchFailed(errorformat.JSON, p.URI, err)
+	}
+	return stat, nil
+}
@@ -0,0 +1,30 @@
+package mpelasticsearch
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/errorformat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, errorformat.IsTransient(&statusError{StatusCode: 503, Status: "503 Service Unavailable"}))
+	assert.False(t, errorformat.IsTransient(&statusError{StatusCode: 401, Status: "401 Unauthorized"}))
+	assert.True(t, errorformat.IsTransient(&requestError{Phase: "connect", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}))
+}
+
+func TestIsTransient_FetchMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL}
+	_, err := elasticsearch.FetchMetrics()
+	assert.True(t, errorformat.IsTransient(err))
+}
@@ -0,0 +1,84 @@
+package mpelasticsearch
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// readNetrc returns the login and password for host from the netrc file at path.
+// It falls back to the default entry if there is no entry for host.
+func readNetrc(path, host string) (login, password string, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", false, err
+	}
+	defer f.Close()
+
+	type entry struct {
+		login, password string
+	}
+	var (
+		machine, fallback *entry
+		cur               *entry
+		inMacro           bool
+		next              string
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro definition ends with an empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, tok := range strings.Fields(line) {
+			switch next {
+			case "machine":
+				cur = nil
+				if tok == host && machine == nil {
+					machine = &entry{}
+					cur = machine
+				}
+			case "login":
+				if cur != nil {
+					cur.login = tok
+				}
+			case "password":
+				if cur != nil {
+					cur.password = tok
+				}
+			}
+			if next != "" {
+				next = ""
+				continue
+			}
+			switch tok {
+			case "default":
+				cur = nil
+				if fallback == nil {
+					fallback = &entry{}
+					cur = fallback
+				}
+			case "macdef":
+				cur = nil
+				inMacro = true
+			case "machine", "login", "password", "account":
+				next = tok
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", false, err
+	}
+	if machine != nil {
+		return machine.login, machine.password, true, nil
+	}
+	if fallback != nil {
+		return fallback.login, fallback.password, true, nil
+	}
+	return "", "", false, nil
+}
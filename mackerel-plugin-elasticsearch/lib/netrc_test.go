@@ -0,0 +1,57 @@
+package mpelasticsearch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadNetrc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	content := `# comment
+machine example.com login alice password secret1
+macdef init
+machine localhost login mallory password evil
+
+machine localhost
+	login bob
+	password secret2
+default login carol password secret3
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host     string
+		login    string
+		password string
+	}{
+		{"example.com", "alice", "secret1"},
+		{"localhost", "bob", "secret2"},
+		{"es.local", "carol", "secret3"},
+	}
+	for _, tt := range tests {
+		login, password, ok, err := readNetrc(path, tt.host)
+		assert.Nil(t, err)
+		assert.True(t, ok, tt.host)
+		assert.Equal(t, tt.login, login, tt.host)
+		assert.Equal(t, tt.password, password, tt.host)
+	}
+}
+
+func TestReadNetrc_NotFound(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(path, []byte("machine example.com login alice password secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, ok, err := readNetrc(path, "localhost")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, _, _, err = readNetrc(filepath.Join(t.TempDir(), "missing"), "localhost")
+	assert.NotNil(t, err)
+}
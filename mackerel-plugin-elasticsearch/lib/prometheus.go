@@ -0,0 +1,94 @@
+package mpelasticsearch
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+var invalidPrometheusChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// prometheusName converts a Mackerel metric key such as "elasticsearch.indices.docs_count"
+// to a Prometheus metric name such as "elasticsearch_indices_docs_count".
+func prometheusName(key string) string {
+	return invalidPrometheusChars.ReplaceAllString(key, "_")
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// wildcardLabel returns the name of the label for the i-th wildcard of a metric key.
+func wildcardLabel(i int) string {
+	if i == 0 {
+		return "name"
+	}
+	return fmt.Sprintf("name%d", i+1)
+}
+
+// writePrometheus writes stat in the Prometheus text exposition format.
+// Metrics taking differences in Mackerel are exposed as counters named with the _total suffix
+// with their raw values, and the others are exposed as gauges.
+// The metrics of a wildcard graph make a family, labeled with the parts matching the wildcards.
+func writePrometheus(w io.Writer, graphs map[string]mp.Graphs, stat map[string]float64) error {
+	graphKeys := make([]string, 0, len(graphs))
+	for k := range graphs {
+		graphKeys = append(graphKeys, k)
+	}
+	slices.Sort(graphKeys)
+
+	for _, gk := range graphKeys {
+		g := graphs[gk]
+		for _, m := range g.Metrics {
+			key := gk + "." + m.Name
+			// samples are pairs of the labels and the value
+			var samples [][2]string
+			if strings.ContainsAny(key, "#*") {
+				// keys of wildcard graphs are stored as they are
+				re := regexp.MustCompile("^" + strings.NewReplacer("#", `([-a-zA-Z0-9_]+)`, `\*`, `([-a-zA-Z0-9_]+)`).Replace(regexp.QuoteMeta(key)) + "$")
+				for k, v := range stat {
+					match := re.FindStringSubmatch(k)
+					if match == nil {
+						continue
+					}
+					labels := make([]string, 0, len(match)-1)
+					for i, s := range match[1:] {
+						labels = append(labels, fmt.Sprintf(`%s="%s"`, wildcardLabel(i), labelValueEscaper.Replace(s)))
+					}
+					samples = append(samples, [2]string{"{" + strings.Join(labels, ",") + "}", formatValue(v)})
+				}
+				slices.SortFunc(samples, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+				key = strings.NewReplacer(".#", "", ".*", "").Replace(key)
+			} else if v, ok := stat[m.Name]; ok {
+				samples = append(samples, [2]string{"", formatValue(v)})
+			}
+			if len(samples) == 0 {
+				continue
+			}
+
+			name, typ := prometheusName(key), "gauge"
+			if m.Diff {
+				name, typ = name+"_total", "counter"
+			}
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n",
+				name, helpEscaper.Replace(strings.TrimSpace(g.Label+" "+m.Label)), name, typ); err != nil {
+				return err
+			}
+			for _, s := range samples {
+				if _, err := fmt.Fprintf(w, "%s%s %s\n", name, s[0], s[1]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,53 @@
+package mpelasticsearch
+
+import (
+	"bytes"
+	"testing"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	graphs := map[string]mp.Graphs{
+		"elasticsearch.indices.docs": {
+			Label: "Elasticsearch Indices Docs",
+			Metrics: []mp.Metrics{
+				{Name: "docs_count", Label: "Count"},
+				{Name: "docs_deleted", Label: "Deleted"},
+			},
+		},
+		"elasticsearch.http": {
+			Label: "Elasticsearch HTTP",
+			Metrics: []mp.Metrics{
+				{Name: "http_opened", Label: "Opened", Diff: true},
+			},
+		},
+		"elasticsearch.script.#": {
+			Label: "Elasticsearch Script",
+			Metrics: []mp.Metrics{
+				{Name: "compilations", Label: "Compilations", Diff: true},
+			},
+		},
+	}
+	stat := map[string]float64{
+		"docs_count":  100,
+		"http_opened": 37,
+		"elasticsearch.script.painless-test.compilations": 2,
+		"elasticsearch.script.watcher.compilations":       5,
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, writePrometheus(&buf, graphs, stat))
+	assert.Equal(t, `# HELP elasticsearch_http_http_opened_total Elasticsearch HTTP Opened
+# TYPE elasticsearch_http_http_opened_total counter
+elasticsearch_http_http_opened_total 37
+# HELP elasticsearch_indices_docs_docs_count Elasticsearch Indices Docs Count
+# TYPE elasticsearch_indices_docs_docs_count gauge
+elasticsearch_indices_docs_docs_count 100
+# HELP elasticsearch_script_compilations_total Elasticsearch Script Compilations
+# TYPE elasticsearch_script_compilations_total counter
+elasticsearch_script_compilations_total{name="painless-test"} 2
+elasticsearch_script_compilations_total{name="watcher"} 5
+`, buf.String())
+}
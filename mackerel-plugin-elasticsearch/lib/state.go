@@ -0,0 +1,113 @@
+package mpelasticsearch
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// stateKeys are the counters which hitRatio and avgTime take differences of.
+var stateKeys = []string{
+	"query_cache_hit", "query_cache_miss",
+	"write_rejected", "write_completed",
+	"total_get_time", "total_get",
+	"total_warmer_time", "total_warmer",
+}
+
+// loadState reads the values saved by the previous run.
+// It returns nil when there is no state yet.
+func loadState(path string) map[string]float64 {
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warningf("Failed to read state: %s", err)
+		}
+		return nil
+	}
+	var values map[string]float64
+	if err := json.Unmarshal(b, &values); err != nil {
+		logger.Warningf("Failed to parse state: %s", err)
+		return nil
+	}
+	return values
+}
+
+// saveState writes the values of stateKeys for the next run.
+func saveState(path string, values map[string]float64) error {
+	if path == "" {
+		return nil
+	}
+	state := make(map[string]float64)
+	for _, k := range stateKeys {
+		if v, ok := values[k]; ok {
+			state[k] = v
+		}
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".mackerel-plugin-elasticsearch-state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// rename(2) replaces the file atomically, so that the next run never reads a truncated state.
+	return os.Rename(f.Name(), path)
+}
+
+// delta returns the increase of the counter key since the previous run.
+// It reports false if either value is missing or the counter seems to be reset.
+func delta(cur, prev map[string]float64, key string) (float64, bool) {
+	c, ok := cur[key]
+	if !ok {
+		return 0, false
+	}
+	p, ok := prev[key]
+	if !ok {
+		return 0, false
+	}
+	if c < p {
+		return 0, false
+	}
+	return c - p, true
+}
+
+// hitRatio returns hit/(hit+miss)*100 over the interval since the previous run.
+func hitRatio(cur, prev map[string]float64, hit, miss string) (float64, bool) {
+	h, ok := delta(cur, prev, hit)
+	if !ok {
+		return 0, false
+	}
+	m, ok := delta(cur, prev, miss)
+	if !ok {
+		return 0, false
+	}
+	if h+m == 0 {
+		return 0, false
+	}
+	return h / (h + m) * 100, true
+}
+
+// avgTime returns the increase of the time per the increase of the count over the interval since the previous run.
+func avgTime(cur, prev map[string]float64, time, count string) (float64, bool) {
+	t, ok := delta(cur, prev, time)
+	if !ok {
+		return 0, false
+	}
+	c, ok := delta(cur, prev, count)
+	if !ok || c == 0 {
+		return 0, false
+	}
+	return t / c, true
+}
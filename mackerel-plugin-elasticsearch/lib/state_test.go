@@ -0,0 +1,54 @@
+package mpelasticsearch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	assert.Nil(t, loadState(path))
+
+	assert.Nil(t, saveState(path, map[string]float64{"query_cache_hit": 10, "docs_count": 5}))
+	assert.Equal(t, map[string]float64{"query_cache_hit": 10}, loadState(path), "only the counters of the ratios are saved")
+
+	assert.Nil(t, saveState(path, map[string]float64{"query_cache_hit": 20}))
+	assert.Equal(t, map[string]float64{"query_cache_hit": 20}, loadState(path))
+	files, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1, "no temporary files are left")
+}
+
+func TestHitRatio(t *testing.T) {
+	prev := map[string]float64{"hit": 10, "miss": 10}
+
+	ratio, ok := hitRatio(map[string]float64{"hit": 40, "miss": 20}, prev, "hit", "miss")
+	assert.True(t, ok)
+	assert.EqualValues(t, 75, ratio)
+
+	_, ok = hitRatio(map[string]float64{"hit": 10, "miss": 10}, prev, "hit", "miss")
+	assert.False(t, ok, "no requests in the interval")
+
+	_, ok = hitRatio(map[string]float64{"hit": 5, "miss": 20}, prev, "hit", "miss")
+	assert.False(t, ok, "counter reset")
+
+	_, ok = hitRatio(map[string]float64{"hit": 40, "miss": 20}, nil, "hit", "miss")
+	assert.False(t, ok, "first run")
+}
+
+func TestAvgTime(t *testing.T) {
+	prev := map[string]float64{"time": 100, "count": 10}
+
+	avg, ok := avgTime(map[string]float64{"time": 130, "count": 22}, prev, "time", "count")
+	assert.True(t, ok)
+	assert.EqualValues(t, 2.5, avg)
+
+	_, ok = avgTime(map[string]float64{"time": 100, "count": 10}, prev, "time", "count")
+	assert.False(t, ok, "no requests in the interval")
+
+	_, ok = avgTime(map[string]float64{"time": 130, "count": 5}, prev, "time", "count")
+	assert.False(t, ok, "counter reset")
+}
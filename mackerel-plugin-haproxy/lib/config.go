@@ -0,0 +1,119 @@
+package mphaproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// loadConfig reads flag values from the TOML or JSON file at path.
+// Keys of the file are flag names, and flags explicitly set on the command line take precedence.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	values, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	return setFlags(fs, path, values)
+}
+
+// credentialKeys are the flags which can be given by a systemd credential.
+var credentialKeys = []string{"uri", "username", "password"}
+
+// loadCredential reads the values of credentialKeys from the systemd credential of name,
+// which is in the same format as the config file, e.g. passed by LoadCredential= of the unit.
+// It does nothing if the credential is not passed to the plugin.
+func loadCredential(fs *flag.FlagSet, name string) error {
+	if name == "" || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("invalid credential name: %q", name)
+	}
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return nil
+	}
+	path := filepath.Join(dir, name)
+	values, err := readConfig(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for name := range values {
+		if !slices.Contains(credentialKeys, name) {
+			return fmt.Errorf("unknown key in %s: %s", path, name)
+		}
+	}
+	return setFlags(fs, path, values)
+}
+
+// targetKeys are the flags which select the target to read the stats from.
+var targetKeys = []string{"socket", "uri", "host", "port", "path", "dataplane-url"}
+
+// socketFromEnv returns the HAPROXY_SOCKET environment variable, or "" if any of targetKeys is set
+// on the command line, in the config file or by the credential, so that it never overrides them.
+// It must be called after loadConfig and loadCredential.
+func socketFromEnv(fs *flag.FlagSet) string {
+	var set bool
+	fs.Visit(func(f *flag.Flag) {
+		if slices.Contains(targetKeys, f.Name) {
+			set = true
+		}
+	})
+	if set {
+		return ""
+	}
+	return os.Getenv("HAPROXY_SOCKET")
+}
+
+// readConfig parses the file at path as TOML if its extension is .toml, or JSON otherwise.
+func readConfig(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	default:
+		err = json.Unmarshal(b, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// setFlags sets the flags to values read from path unless they are explicitly set.
+func setFlags(fs *flag.FlagSet, path string, values map[string]any) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, v := range values {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown key in %s: %s", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		vs, ok := v.([]any)
+		if !ok {
+			vs = []any{v}
+		}
+		for _, s := range vs {
+			if err := fs.Set(name, fmt.Sprint(s)); err != nil {
+				return fmt.Errorf("invalid value for %s in %s: %w", name, path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package mphaproxy
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		Name    string
+		File    string
+		Content string
+	}{
+		{
+			Name:    "toml",
+			File:    "haproxy.toml",
+			Content: "uri = \"http://lb.example.com/stats\"\nusername = \"admin\"\n",
+		},
+		{
+			Name:    "json",
+			File:    "haproxy.json",
+			Content: `{"uri": "http://lb.example.com/stats", "username": "admin"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.File)
+			require.NoError(t, os.WriteFile(path, []byte(tt.Content), 0600))
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			uri := fs.String("uri", "", "")
+			username := fs.String("username", "", "")
+			require.NoError(t, fs.Parse([]string{"-username", "root"}))
+
+			require.NoError(t, loadConfig(fs, path))
+			assert.Equal(t, "http://lb.example.com/stats", *uri)
+			assert.Equal(t, "root", *username, "command line flags should take precedence")
+		})
+	}
+}
+
+func TestLoadConfig_UnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "haproxy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"unknown": "x"}`), 0600))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	assert.Error(t, loadConfig(fs, path))
+}
+
+func TestSocketFromEnv(t *testing.T) {
+	t.Setenv("HAPROXY_SOCKET", "/run/haproxy/admin.sock")
+	path := filepath.Join(t.TempDir(), "haproxy.toml")
+	require.NoError(t, os.WriteFile(path, []byte("uri = \"http://lb.example.com/stats\"\n"), 0600))
+
+	tests := []struct {
+		Name   string
+		Args   []string
+		Config bool
+		Want   string
+	}{
+		{Name: "no target", Want: "/run/haproxy/admin.sock"},
+		{Name: "other flags", Args: []string{"-username", "admin"}, Want: "/run/haproxy/admin.sock"},
+		{Name: "socket", Args: []string{"-socket", "/tmp/haproxy.sock"}},
+		{Name: "uri", Args: []string{"-uri", "http://localhost/stats"}},
+		{Name: "host", Args: []string{"-host", "lb.example.com"}},
+		{Name: "port", Args: []string{"-port", "8080"}},
+		{Name: "path", Args: []string{"-path", "/stats"}},
+		{Name: "dataplane-url", Args: []string{"-dataplane-url", "http://localhost:5555/v2/services/haproxy/stats/native"}},
+		{Name: "config", Config: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, name := range targetKeys {
+				fs.String(name, "", "")
+			}
+			fs.String("username", "", "")
+			require.NoError(t, fs.Parse(tt.Args))
+			if tt.Config {
+				require.NoError(t, loadConfig(fs, path))
+			}
+			assert.Equal(t, tt.Want, socketFromEnv(fs))
+		})
+	}
+}
+
+func TestLoadCredential(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "haproxy.toml"), []byte("username = \"admin\"\npassword = \"secret\"\n"), 0600))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	username := fs.String("username", "", "")
+	password := fs.String("password", "env", "")
+	require.NoError(t, fs.Parse([]string{"-username", "root"}))
+
+	require.NoError(t, loadCredential(fs, "haproxy.toml"))
+	assert.Equal(t, "root", *username, "command line flags should take precedence")
+	assert.Equal(t, "secret", *password)
+}
+
+func TestLoadCredential_NotPresent(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	password := fs.String("password", "env", "")
+
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	require.NoError(t, loadCredential(fs, "haproxy"))
+	t.Setenv("CREDENTIALS_DIRECTORY", t.TempDir())
+	require.NoError(t, loadCredential(fs, "haproxy"))
+	assert.Equal(t, "env", *password)
+}
+
+func TestLoadCredential_UnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "haproxy"), []byte(`{"socket": "/tmp/admin.sock"}`), 0600))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("socket", "", "")
+	assert.Error(t, loadCredential(fs, "haproxy"))
+	assert.Error(t, loadCredential(fs, "../haproxy"))
+}
@@ -0,0 +1,27 @@
+package mphaproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/errorformat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, errorformat.IsTransient(&statusError{StatusCode: 503, Status: "503 Service Unavailable"}))
+	assert.False(t, errorformat.IsTransient(&statusError{StatusCode: 401, Status: "401 Unauthorized"}))
+}
+
+func TestIsTransient_FetchMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	haproxy := HAProxyPlugin{URI: ts.URL}
+	_, err := haproxy.FetchMetrics()
+	assert.Error(t, err)
+	assert.False(t, errorformat.IsTransient(err))
+}
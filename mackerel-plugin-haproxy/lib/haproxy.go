@@ -2,20 +2,37 @@ package mphaproxy
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log"
+	"maps"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/errorformat"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/plugincommon"
 )
 
+var logger = logging.GetLogger("metrics.plugin.haproxy")
+
 var graphdef = map[string]mp.Graphs{
 	"haproxy.total.sessions": {
 		Label: "HAProxy Total Sessions",
@@ -39,34 +56,340 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "connection_errors", Label: "Connection Errors", Diff: true},
 		},
 	},
+	"haproxy.total.frontend_errors": {
+		Label: "HAProxy Total Frontend Errors",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "frontend_request_errors", Label: "Request Errors", Diff: true},
+		},
+	},
+	"haproxy.total.session_headroom": {
+		Label: "HAProxy Total Session Rate Headroom",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "session_rate_headroom_percent", Label: "Usage of Limit"},
+		},
+	},
+	"haproxy.backend": {
+		Label: "HAProxy Backup Servers",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "backup_servers_active", Label: "Serving Backup Servers"},
+		},
+	},
+}
+
+var perBackendGraphdef = map[string]mp.Graphs{
+	"haproxy.backend.sessions.#": {
+		Label: "HAProxy Backend Sessions",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "sessions", Label: "Sessions", Diff: true},
+		},
+	},
+	"haproxy.backend.bytes.#": {
+		Label: "HAProxy Backend Bytes",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "bytes_in", Label: "Bytes In", Diff: true},
+			{Name: "bytes_out", Label: "Bytes Out", Diff: true},
+		},
+	},
+	"haproxy.backend.connection_errors.#": {
+		Label: "HAProxy Backend Connection Errors",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "connection_errors", Label: "Connection Errors", Diff: true},
+		},
+	},
+	"haproxy.backend.status.#": {
+		Label: "HAProxy Backend Status",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "backend_status", Label: "Status"},
+		},
+	},
+	"haproxy.backend.check_status.#": {
+		Label: "HAProxy Backend Check Status",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "*", Label: "%1"},
+		},
+	},
+	"haproxy.backend.agent_status.#": {
+		Label: "HAProxy Backend Agent Check Status",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "*", Label: "%1"},
+		},
+	},
+}
+
+// backendStatus maps the status column to stable values.
+// Don't change them since users set alerts on them.
+var backendStatus = map[string]float64{
+	"DOWN":  0,
+	"UP":    1,
+	"NOLB":  2,
+	"MAINT": 3,
+	"DRAIN": 4,
+}
+
+// checkStatus maps the check_status and agent_status columns to stable values.
+// The tens digit is the layer of the check, and the ones digit 0 means succeeded.
+var checkStatus = map[string]float64{
+	"UNK":      0,
+	"INI":      1,
+	"SOCKERR":  2,
+	"L4OK":     10,
+	"L4TOUT":   11,
+	"L4CON":    12,
+	"L6OK":     20,
+	"L6TOUT":   21,
+	"L6RSP":    22,
+	"L7OK":     30,
+	"L7OKC":    31,
+	"L7TOUT":   32,
+	"L7RSP":    33,
+	"L7STS":    34,
+	"PROCOK":   40,
+	"PROCTOUT": 41,
+	"PROCERR":  42,
+}
+
+// rawGraphdef reports the cumulative counters as they are.
+// A sudden drop of them indicates HAProxy was reloaded.
+var rawGraphdef = map[string]mp.Graphs{
+	"haproxy.total.sessions_raw": {
+		Label: "HAProxy Total Sessions (Raw)",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "sessions_total_raw", Label: "Sessions"},
+		},
+	},
+	"haproxy.total.bytes_raw": {
+		Label: "HAProxy Total Bytes (Raw)",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "bytes_in_total_raw", Label: "Bytes In"},
+			{Name: "bytes_out_total_raw", Label: "Bytes Out"},
+		},
+	},
+	"haproxy.total.connection_errors_raw": {
+		Label: "HAProxy Total Connection Errors (Raw)",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "connection_errors_total_raw", Label: "Connection Errors"},
+		},
+	},
+}
+
+// processGraphdef is for the info of the process, which is available only with the stats socket.
+var processGraphdef = map[string]mp.Graphs{
+	"haproxy.process": {
+		Label: "HAProxy Process Uptime",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "uptime", Label: "Uptime (sec)"},
+		},
+	},
+	"haproxy.process.ssl": {
+		Label: "HAProxy Process SSL Rate",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "ssl_rate", Label: "Sessions"},
+			{Name: "ssl_rate_max", Label: "Max Sessions"},
+			{Name: "ssl_frontend_key_rate", Label: "Frontend Key Computations"},
+		},
+	},
+}
+
+// infoMetrics maps the fields of "show info" to the metrics.
+// The SSL fields are missing unless HAProxy is built with SSL.
+var infoMetrics = map[string]string{
+	// the uptime drops on a reload, which explains the counters reset
+	"Uptime_sec": "uptime",
+	// per second
+	"SslRate":            "ssl_rate",
+	"MaxSslRate":         "ssl_rate_max",
+	"SslFrontendKeyRate": "ssl_frontend_key_rate",
+}
+
+var scrapeDurationGraphdef = map[string]mp.Graphs{
+	"haproxy.plugin": {
+		Label: "HAProxy Plugin Scrape Duration",
+		Unit:  "milliseconds",
+		Metrics: []mp.Metrics{
+			{Name: "scrape_duration_ms", Label: "Duration"},
+		},
+	},
 }
 
+// bytesGraphs are the graphs of the throughput in bytes, which are reported in bits with -bits.
+var bytesGraphs = []string{"haproxy.total.bytes", "haproxy.backend.bytes.#"}
+
+var counterMetrics = []string{"sessions", "bytes_in", "bytes_out", "connection_errors"}
+
 // HAProxyPlugin mackerel plugin for haproxy
 type HAProxyPlugin struct {
-	URI      string
-	Username string
-	Password string
-	Socket   string
+	URI                string
+	Username           string
+	Password           string
+	Socket             string
+	PerBackend         bool
+	EmitRaw            bool
+	Header             []string
+	EmitScrapeDuration bool
+	Scope              string
+	OnlyDown           bool
+	HostHeader         string
+	Timeout            time.Duration
+	ConnectTimeout     time.Duration
+	Interval           time.Duration
+	DataplaneURL       string
+	Bits               bool
+	NameMap            map[string]string
+	WarnEmpty          bool
+}
+
+const defaultTimeout = 5 * time.Second
+
+// timeout returns the timeout to fetch the stats.
+func (p HAProxyPlugin) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return defaultTimeout
+}
+
+// connectTimeout returns the timeout to connect to the stats socket, which defaults to the timeout to fetch the stats.
+func (p HAProxyPlugin) connectTimeout() time.Duration {
+	if p.ConnectTimeout > 0 {
+		return p.ConnectTimeout
+	}
+	return p.timeout()
+}
+
+// target returns where the stats are fetched from, with the password in the URL redacted.
+func (p HAProxyPlugin) target() string {
+	switch {
+	case p.DataplaneURL != "":
+		return redactURL(p.DataplaneURL)
+	case p.Socket == "":
+		return redactURL(p.URI)
+	default:
+		return p.Socket
+	}
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// downOutput is where the backends not UP are written with -only-down.
+var downOutput io.Writer = os.Stderr
+
+var normalizeMetricRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeMetricName(str string) string {
+	return normalizeMetricRe.ReplaceAllString(str, "_")
+}
+
+// proxyName returns the name of the proxy in the metric keys, which is the friendly name in NameMap if any.
+func (p HAProxyPlugin) proxyName(pxname string) string {
+	if name, ok := p.NameMap[pxname]; ok {
+		pxname = name
+	}
+	return normalizeMetricName(pxname)
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p HAProxyPlugin) FetchMetrics() (map[string]float64, error) {
+	start := time.Now()
 	var metrics map[string]float64
 	var err error
-	if p.Socket == "" {
+	switch {
+	case p.DataplaneURL != "":
+		metrics, err = p.fetchMetricsFromDataplane()
+	case p.Socket == "":
 		metrics, err = p.fetchMetricsFromTCP()
-	} else {
+	default:
 		metrics, err = p.fetchMetricsFromSocket()
 	}
-	return metrics, err
+	if err != nil {
+		return nil, err
+	}
+	if p.WarnEmpty && isEmpty(metrics) {
+		logger.Warningf("All of %s are zero, the stats of %s may be misread", strings.Join(summedMetrics, ", "), p.target())
+	}
+	if p.EmitRaw {
+		for _, name := range counterMetrics {
+			if v, ok := metrics[name]; ok {
+				metrics[name+"_total_raw"] = v
+			}
+		}
+	}
+	if p.EmitScrapeDuration {
+		metrics["scrape_duration_ms"] = float64(time.Since(start)) / float64(time.Millisecond)
+	}
+	return metrics, nil
+}
+
+// summedMetrics are the metrics summed up over the BACKEND rows.
+var summedMetrics = []string{"sessions", "bytes_in", "bytes_out", "connection_errors"}
+
+// isEmpty reports whether all the summedMetrics are zero despite the BACKEND rows being parsed.
+// It almost always means that the columns are misread, e.g. after upgrading HAProxy, rather than an idle HAProxy.
+func isEmpty(stat map[string]float64) bool {
+	for _, name := range summedMetrics {
+		if v, ok := stat[name]; !ok || v != 0 {
+			return false
+		}
+	}
+	return true
 }
 
 func (p HAProxyPlugin) fetchMetricsFromTCP() (map[string]float64, error) {
+	requestURI := p.URI + ";csv;norefresh"
+	if p.Scope != "" {
+		requestURI += ";scope=" + url.QueryEscape(p.Scope)
+	}
+	// some proxies in front of the stats page negotiate to HTML otherwise
+	return p.fetchHTTP(requestURI, "text/plain", p.parseStats)
+}
+
+// fetchMetricsFromDataplane reads stats from the Data Plane API.
+func (p HAProxyPlugin) fetchMetricsFromDataplane() (map[string]float64, error) {
+	return p.fetchHTTP(p.DataplaneURL, "application/json", p.parseDataplaneStats)
+}
+
+// statusError is returned by fetchHTTP when the stats page responds with a non-200 status.
+type statusError struct {
+	StatusCode int
+	Status     string
+	URI        string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Request failed. Status: %s, URI: %s", e.Status, e.URI) // nolint
+}
+
+// HTTPStatusCode returns the status code for errorformat.IsTransient.
+func (e *statusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// fetchHTTP requests requestURI accepting the media type accept and parses the response body with parse.
+// The Accept header can be overridden by -header.
+func (p HAProxyPlugin) fetchHTTP(requestURI, accept string, parse func(io.Reader) (map[string]float64, error)) (map[string]float64, error) {
 	client := &http.Client{
-		Timeout: time.Duration(5) * time.Second,
+		Timeout: p.timeout(),
 	}
 
-	requestURI := p.URI + ";csv;norefresh"
 	req, err := http.NewRequest("GET", requestURI, nil)
 	if err != nil {
 		return nil, err
@@ -75,6 +398,18 @@ func (p HAProxyPlugin) fetchMetricsFromTCP() (map[string]float64, error) {
 		req.SetBasicAuth(p.Username, p.Password)
 	}
 	req.Header.Set("User-Agent", "mackerel-plugin-haproxy")
+	// ask explicitly so that a custom transport doesn't lose the compression;
+	// the transport leaves the body compressed then, so it is decoded below
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Accept", accept)
+	plugincommon.SetHeaders(req, p.Header)
+	if p.HostHeader != "" {
+		// verify the certificate for the virtual host rather than the address dialed
+		req.Host = p.HostHeader
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = &tls.Config{ServerName: hostname(p.HostHeader)}
+		client.Transport = t
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -83,27 +418,237 @@ func (p HAProxyPlugin) fetchMetricsFromTCP() (map[string]float64, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Request failed. Status: %s, URI: %s", resp.Status, requestURI) // nolint
+		return nil, &statusError{StatusCode: resp.StatusCode, Status: resp.Status, URI: requestURI}
 	}
 
-	return p.parseStats(resp.Body)
+	body := io.Reader(resp.Body)
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	}
+	return parse(body)
 }
 
+// fetchMetricsFromSocket reads stats from the sockets matching p.Socket.
+// In multi-process mode, each process has its own socket and counters, so the counters are summed up.
 func (p HAProxyPlugin) fetchMetricsFromSocket() (map[string]float64, error) {
-	client, err := net.Dial("unix", p.Socket)
+	sockets, err := filepath.Glob(p.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if len(sockets) == 0 {
+		// let net.Dial report the error
+		sockets = []string{p.Socket}
+	}
+
+	stat := make(map[string]float64)
+	for _, socket := range sockets {
+		s, err := p.fetchMetricsFromSocketPath(socket)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", socket, err)
+		}
+		mergeStats(stat, s)
+	}
+	return stat, nil
+}
+
+func (p HAProxyPlugin) fetchMetricsFromSocketPath(socket string) (map[string]float64, error) {
+	outputs, err := p.sendCommands(socket, "show info", "show stat")
+	if err != nil {
+		return nil, err
+	}
+	stat, err := p.parseStats(outputs[1])
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := parseInfo(outputs[0])
+	if err != nil {
+		logger.Warningf("Failed to parse info from %s: %s", socket, err)
+		return stat, nil
+	}
+	for field, name := range infoMetrics {
+		if v, err := strconv.ParseFloat(info[field], 64); err == nil {
+			stat[name] = v
+		}
+	}
+	return stat, nil
+}
+
+// sendCommands sends the commands at once, separated by semicolons, on a single connection to the socket,
+// and returns the output of each command.
+func (p HAProxyPlugin) sendCommands(socket string, commands ...string) ([]io.Reader, error) {
+	dialer := net.Dialer{Timeout: p.connectTimeout()}
+	client, err := dialer.Dial("unix", socket)
 	if err != nil {
 		return nil, err
 	}
 	defer client.Close()
+	if err := client.SetDeadline(time.Now().Add(p.timeout())); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(client, strings.Join(commands, ";"))
+
+	outputs, err := splitOutputs(client)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputs) != len(commands) {
+		return nil, fmt.Errorf("got %d outputs for %d commands", len(outputs), len(commands))
+	}
+	return outputs, nil
+}
 
-	fmt.Fprintln(client, "show stat")
+// parseInfo parses the "Name: value" lines of "show info".
+func parseInfo(r io.Reader) (map[string]string, error) {
+	info := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if k, v, ok := strings.Cut(scanner.Text(), ":"); ok {
+			info[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
 
-	return p.parseStats(bufio.NewReader(client))
+// splitOutputs splits the outputs of the commands sent at once, each of which HAProxy terminates with an empty line.
+// The "> " prompts of the interactive mode of the stats socket, which precede the outputs
+// and follow the last one on their own line, are removed.
+// Proxy names never start with ">", so the stats themselves are left as they are.
+func splitOutputs(r io.Reader) ([]io.Reader, error) {
+	var outputs []io.Reader
+	var buf bytes.Buffer
+	flush := func() {
+		if buf.Len() > 0 {
+			outputs = append(outputs, bytes.NewReader(bytes.Clone(buf.Bytes())))
+			buf.Reset()
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), "> ")
+		switch strings.TrimSpace(line) {
+		case "":
+			flush()
+		case ">":
+		default:
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return outputs, nil
+}
+
+// mergeStats adds the counters of src to dst.
+// Every process sees the same backends and servers, so their statuses and the number of the serving
+// backup servers are taken from the first process.
+// The uptime is the shortest one, so that a restart of any process is seen.
+func mergeStats(dst, src map[string]float64) {
+	for k, v := range src {
+		if k == "uptime" {
+			if d, ok := dst[k]; !ok || v < d {
+				dst[k] = v
+			}
+			continue
+		}
+		if k == "session_rate_headroom_percent" {
+			if d, ok := dst[k]; !ok || v > d {
+				dst[k] = v
+			}
+			continue
+		}
+		if k == "backup_servers_active" || strings.HasSuffix(k, ".backend_status") || strings.HasPrefix(k, "haproxy.backend.check_status.") || strings.HasPrefix(k, "haproxy.backend.agent_status.") {
+			if _, ok := dst[k]; !ok {
+				dst[k] = v
+			}
+			continue
+		}
+		dst[k] += v
+	}
+}
+
+// skipLeadingSpace skips a UTF-8 BOM and whitespaces at the beginning of r,
+// which are injected by some proxies in front of the stats page.
+func skipLeadingSpace(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	for {
+		c, _, err := br.ReadRune()
+		if err != nil {
+			return br
+		}
+		if c != '\uFEFF' && !unicode.IsSpace(c) {
+			br.UnreadRune() // nolint
+			return br
+		}
+	}
+}
+
+// hostname returns host without the port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// defaultColumns are the positions of the columns used when the stats have no header line.
+var defaultColumns = map[string]int{
+	"pxname":       0,
+	"svname":       1,
+	"stot":         7,
+	"bin":          8,
+	"bout":         9,
+	"ereq":         12,
+	"econ":         13,
+	"status":       17,
+	"act":          19,
+	"bck":          20,
+	"check_status": 36,
+}
+
+// optionalColumns are the positions of the columns which older versions don't have, used when the stats have no header line.
+var optionalColumns = map[string]int{
+	"scur":         4,
+	"slim":         6,
+	"rate":         33,
+	"rate_lim":     34,
+	"agent_status": 62,
+}
+
+// parseHeader builds the positions of the columns from the header line such as "# pxname,svname,...".
+func parseHeader(header []string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimSpace(strings.TrimPrefix(name, "#"))
+		}
+		index[name] = i
+	}
+	for name := range defaultColumns {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("column %s is not found in the header of stats csv", name)
+		}
+	}
+	return index, nil
 }
 
 func (p HAProxyPlugin) parseStats(statsBody io.Reader) (map[string]float64, error) {
 	stat := make(map[string]float64)
-	reader := csv.NewReader(statsBody)
+	reader := csv.NewReader(skipLeadingSpace(statsBody))
+	index := maps.Clone(defaultColumns)
+	maps.Copy(index, optionalColumns)
 
 	for {
 		columns, err := reader.Read()
@@ -115,43 +660,255 @@ func (p HAProxyPlugin) parseStats(statsBody io.Reader) (map[string]float64, erro
 			return nil, errors.New("length of stats csv is too short (specified uri/socket may be wrong)")
 		}
 
-		if columns[1] != "BACKEND" {
+		if strings.HasPrefix(columns[0], "#") {
+			index, err = parseHeader(columns)
+			if err != nil {
+				return nil, err
+			}
 			continue
 		}
 
-		var data float64
+		field := func(name string) string {
+			i, ok := index[name]
+			if !ok || i >= len(columns) {
+				return ""
+			}
+			return columns[i]
+		}
+		if err := p.parseRow(stat, field); err != nil {
+			return nil, err
+		}
+	}
+
+	return stat, nil
+}
 
-		data, err = strconv.ParseFloat(columns[7], 64)
+// sessionHeadroom returns the usage of the limit of the session rate of a frontend in percent,
+// or of the limit of the concurrent sessions if the session rate isn't limited.
+// It reports false if neither is limited.
+func sessionHeadroom(field func(name string) string) (float64, bool) {
+	for _, c := range [][2]string{{"rate", "rate_lim"}, {"scur", "slim"}} {
+		cur, err := strconv.ParseFloat(field(c[0]), 64)
 		if err != nil {
-			return nil, errors.New("cannot get values")
+			continue
+		}
+		limit, err := strconv.ParseFloat(field(c[1]), 64)
+		if err != nil || limit <= 0 {
+			continue
 		}
-		stat["sessions"] += data
+		return cur / limit * 100, true
+	}
+	return 0, false
+}
 
-		data, err = strconv.ParseFloat(columns[8], 64)
+// parseRow adds the stats of a proxy or a server to stat.
+// field returns the value of the column of the stats csv by name.
+func (p HAProxyPlugin) parseRow(stat map[string]float64, field func(name string) string) error {
+	value := func(name string) (float64, error) {
+		s := field(name)
+		// an empty column, or a field left out by the Data Plane API, has nothing to count
+		if s == "" {
+			return 0, nil
+		}
+		data, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			return nil, errors.New("cannot get values")
+			return 0, errors.New("cannot get values")
 		}
-		stat["bytes_in"] += data
+		return data, nil
+	}
 
-		data, err = strconv.ParseFloat(columns[9], 64)
+	pxname, svname := field("pxname"), field("svname")
+	if svname == "FRONTEND" {
+		data, err := value("ereq")
 		if err != nil {
-			return nil, errors.New("cannot get values")
+			return err
+		}
+		stat["frontend_request_errors"] += data
+
+		// the frontend closest to its limit
+		if v, ok := sessionHeadroom(field); ok {
+			stat["session_rate_headroom_percent"] = max(stat["session_rate_headroom_percent"], v)
+		}
+		return nil
+	}
+
+	if svname != "BACKEND" {
+		if p.PerBackend {
+			// "* " is prepended while the check is in progress
+			s := strings.TrimSpace(strings.TrimPrefix(field("check_status"), "* "))
+			if v, ok := checkStatus[s]; ok {
+				stat["haproxy.backend.check_status."+p.proxyName(pxname)+"."+normalizeMetricName(svname)] = v
+			}
+			// empty without agent-check
+			s = strings.TrimSpace(strings.TrimPrefix(field("agent_status"), "* "))
+			if v, ok := checkStatus[s]; ok {
+				stat["haproxy.backend.agent_status."+p.proxyName(pxname)+"."+normalizeMetricName(svname)] = v
+			}
 		}
-		stat["bytes_out"] += data
+		return nil
+	}
+
+	var data float64
+	var backend string
+	if p.PerBackend {
+		backend = p.proxyName(pxname)
+	}
 
-		data, err = strconv.ParseFloat(columns[13], 64)
+	// backup servers take over only when no active server is up
+	act, err := value("act")
+	if err != nil {
+		return err
+	}
+	var backups float64
+	if act == 0 {
+		backups, err = value("bck")
 		if err != nil {
-			return nil, errors.New("cannot get values")
+			return err
+		}
+	}
+	stat["backup_servers_active"] += backups
+
+	data, err = value("stot")
+	if err != nil {
+		return err
+	}
+	stat["sessions"] += data
+	if backend != "" {
+		stat["haproxy.backend.sessions."+backend+".sessions"] += data
+	}
+
+	data, err = value("bin")
+	if err != nil {
+		return err
+	}
+	stat["bytes_in"] += data
+	if backend != "" {
+		stat["haproxy.backend.bytes."+backend+".bytes_in"] += data
+	}
+
+	data, err = value("bout")
+	if err != nil {
+		return err
+	}
+	stat["bytes_out"] += data
+	if backend != "" {
+		stat["haproxy.backend.bytes."+backend+".bytes_out"] += data
+	}
+
+	data, err = value("econ")
+	if err != nil {
+		return err
+	}
+	stat["connection_errors"] += data
+	if backend != "" {
+		stat["haproxy.backend.connection_errors."+backend+".connection_errors"] += data
+
+		// the status may have a suffix such as "UP 1/3" or "MAINT (via be/srv)"
+		if f := strings.Fields(field("status")); len(f) > 0 {
+			if v, ok := backendStatus[f[0]]; ok {
+				stat["haproxy.backend.status."+backend+".backend_status"] = v
+			}
+		}
+	}
+
+	if p.OnlyDown {
+		status := field("status")
+		if f := strings.Fields(status); len(f) == 0 || f[0] != "UP" {
+			fmt.Fprintf(downOutput, "%s\tstatus=%s\tsessions=%s\tconnection_errors=%s\n",
+				pxname, status, field("stot"), field("econ"))
 		}
-		stat["connection_errors"] += data
 	}
+	return nil
+}
+
+// dataplaneStats is the response of the native stats of the Data Plane API,
+// which has the stats for each runtime API, i.e. each process.
+type dataplaneStats []struct {
+	RuntimeAPI string `json:"runtimeAPI"`
+	Error      string `json:"error"`
+	Stats      []struct {
+		Name        string         `json:"name"`
+		Type        string         `json:"type"`
+		BackendName string         `json:"backend_name"`
+		Stats       map[string]any `json:"stats"`
+	} `json:"stats"`
+}
 
+// parseDataplaneStats parses the native stats of the Data Plane API, whose fields are named after the columns of the stats csv.
+func (p HAProxyPlugin) parseDataplaneStats(body io.Reader) (map[string]float64, error) {
+	var stats dataplaneStats
+	if err := json.NewDecoder(body).Decode(&stats); err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for _, api := range stats {
+		if api.Error != "" {
+			return nil, fmt.Errorf("%s: %s", api.RuntimeAPI, api.Error)
+		}
+		for _, s := range api.Stats {
+			pxname, svname := s.Name, strings.ToUpper(s.Type)
+			if s.Type == "server" {
+				pxname, svname = s.BackendName, s.Name
+			}
+			field := func(name string) string {
+				switch name {
+				case "pxname":
+					return pxname
+				case "svname":
+					return svname
+				}
+				switch v := s.Stats[name].(type) {
+				case nil:
+					return ""
+				case float64:
+					return strconv.FormatFloat(v, 'f', -1, 64)
+				default:
+					return fmt.Sprint(v)
+				}
+			}
+			if err := p.parseRow(stat, field); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return stat, nil
 }
 
 // GraphDefinition interface for mackerelplugin
 func (p HAProxyPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+	graphs := maps.Clone(graphdef)
+	if p.PerBackend {
+		maps.Copy(graphs, perBackendGraphdef)
+	}
+	if p.EmitRaw {
+		maps.Copy(graphs, rawGraphdef)
+	}
+	if p.Socket != "" {
+		maps.Copy(graphs, processGraphdef)
+	}
+	if p.EmitScrapeDuration {
+		maps.Copy(graphs, scrapeDurationGraphdef)
+	}
+	if p.Bits {
+		for _, k := range bytesGraphs {
+			g, ok := graphs[k]
+			if !ok {
+				continue
+			}
+			g.Label = strings.Replace(g.Label, "Bytes", "Bits", 1)
+			g.Metrics = slices.Clone(g.Metrics)
+			for i := range g.Metrics {
+				g.Metrics[i].Label = strings.Replace(g.Metrics[i].Label, "Bytes", "Bits", 1)
+				g.Metrics[i].Scale = 8
+			}
+			graphs[k] = g
+		}
+	}
+	if p.Interval > 0 {
+		plugincommon.ScaleDiffs(graphs, p.Interval)
+	}
+	return graphs
 }
 
 // Do the plugin
@@ -164,9 +921,56 @@ func Do() {
 	optUsername := flag.String("username", "", "Username for Basic Auth")
 	optPassword := flag.String("password", os.Getenv("HAPROXY_PASSWORD"), "Password for Basic Auth")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
-	optSocket := flag.String("socket", "", "Unix Domain Socket")
+	optSocket := flag.String("socket", "", "Unix Domain Socket `path`, which may be a glob pattern to read from multiple processes")
+	optPerBackend := flag.Bool("per-backend", false, "Emit metrics for each backend in addition to the totals")
+	optEmitRaw := flag.Bool("emit-raw", false, "Also emit the cumulative counters without taking differences")
+	optStrict := flag.Bool("strict", false, "Exit with an error if the tempfile is not writable")
+	optQuiet := flag.Bool("quiet", false, "Suppress all non-fatal log messages")
+	optEmitScrapeDuration := flag.Bool("emit-scrape-duration", false, "Also emit the time taken to fetch the metrics")
+	var optHeader plugincommon.HeaderFlag
+	flag.Var(&optHeader, "header", "Set http header (e.g. \"X-Api-Key: secret\"), can be specified multiple times")
+	optOnlyDown := flag.Bool("only-down", false, "Also print the backends not UP to stderr for triage")
+	optHostHeader := flag.String("host-header", "", "Override the Host header, e.g. to scrape through an ingress routing by Host")
+	optScope := flag.String("scope", "", "Limit the stats page to the proxies whose names contain the `name`")
+	optTimeout := flag.Duration("timeout", defaultTimeout, "Timeout to fetch the stats")
+	optConnectTimeout := flag.Duration("connect-timeout", 0, "Timeout to connect to the stats socket (default: same as -timeout)")
+	optDataplaneURL := flag.String("dataplane-url", "", "URL of the native stats of the Data Plane API, e.g. http://localhost:5555/v2/services/haproxy/stats/native")
+	optBits := flag.Bool("bits", false, "Report the throughput in bits instead of bytes")
+	optNameMap := flag.String("name-map", "", "Use the friendly names of the proxies in the `file` of \"pxname=FriendlyName\" lines in the metric keys with -per-backend")
+	optInterval := flag.Duration("interval", 0, "Report the metrics taking differences per this `duration` instead of per minute, e.g. 1s for per second")
+	optWarnEmpty := flag.Bool("warn-empty", false, "Log a warning when the sessions, bytes and connection errors summed over the backends are all zero")
+	optErrorFormat := flag.String("error-format", errorformat.Text, "`format` of the error written to stderr when the metrics can't be fetched (text or json)")
+	optConfig := flag.String("config", "", "TOML or JSON `file` whose keys are the flag names")
+	optCredentialName := flag.String("credential-name", "", "Read uri, username and password from the systemd credential of the `name` in the format of -config")
 	flag.Parse()
 
+	// the credential takes precedence over the config file
+	if *optCredentialName != "" {
+		if err := loadCredential(flag.CommandLine, *optCredentialName); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *optConfig != "" {
+		if err := loadConfig(flag.CommandLine, *optConfig); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if socket := socketFromEnv(flag.CommandLine); socket != "" {
+		*optSocket = socket
+	}
+
+	if *optErrorFormat != errorformat.Text && *optErrorFormat != errorformat.JSON {
+		log.Fatalf("unknown error format: %s", *optErrorFormat)
+	}
+	if *optScope != "" && *optDataplaneURL != "" {
+		log.Fatalln("-scope can't be used with -dataplane-url")
+	}
+
+	if *optQuiet {
+		logging.SetLogLevel(logging.CRITICAL)
+	}
+
 	var haproxy HAProxyPlugin
 	if *optURI != "" {
 		haproxy.URI = *optURI
@@ -185,9 +989,39 @@ func Do() {
 	if *optSocket != "" {
 		haproxy.Socket = *optSocket
 	}
+	haproxy.PerBackend = *optPerBackend
+	haproxy.EmitRaw = *optEmitRaw
+	haproxy.Header = optHeader
+	haproxy.EmitScrapeDuration = *optEmitScrapeDuration
+	haproxy.Scope = *optScope
+	haproxy.OnlyDown = *optOnlyDown
+	haproxy.HostHeader = *optHostHeader
+	haproxy.Timeout = *optTimeout
+	haproxy.ConnectTimeout = *optConnectTimeout
+	haproxy.Interval = *optInterval
+	haproxy.DataplaneURL = *optDataplaneURL
+	haproxy.Bits = *optBits
+	haproxy.WarnEmpty = *optWarnEmpty
+	if *optNameMap != "" {
+		names, err := readNameMap(*optNameMap)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		haproxy.NameMap = names
+	}
 
-	helper := mp.NewMackerelPlugin(haproxy)
+	var plugin mp.Plugin = haproxy
+	if *optErrorFormat == errorformat.JSON {
+		plugin = jsonErrorPlugin{haproxy}
+	}
+	helper := mp.NewMackerelPlugin(plugin)
 	helper.Tempfile = *optTempfile
+	if err := plugincommon.CheckTempfile(helper.Tempfile); err != nil {
+		if *optStrict {
+			log.Fatalf("tempfile is not writable: %s", err)
+		}
+		logger.Warningf("tempfile is not writable, so metrics taking differences will be wrong: %s", err)
+	}
 
 	helper.Run()
 }
@@ -0,0 +1,37 @@
+package mphaproxy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readNameMap reads the friendly names of the proxies from the file at path.
+// Each line is "pxname=FriendlyName", and empty lines and lines starting with # are ignored.
+func readNameMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pxname, name, ok := strings.Cut(line, "=")
+		pxname, name = strings.TrimSpace(pxname), strings.TrimSpace(name)
+		if !ok || pxname == "" || name == "" {
+			return nil, fmt.Errorf("invalid line %d in %s: must be \"pxname=FriendlyName\"", n, path)
+		}
+		names[pxname] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,31 @@
+package mphaproxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadNameMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names")
+	content := "# backends\nbe_app_8080=app\n\n be_api = API Server \n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := readNameMap(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"be_app_8080": "app", "be_api": "API Server"}, names)
+}
+
+func TestReadNameMap_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names")
+	if err := os.WriteFile(path, []byte("be_app_8080=app\nbe_api\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := readNameMap(path)
+	assert.ErrorContains(t, err, "invalid line 2")
+}
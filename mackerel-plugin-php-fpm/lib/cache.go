@@ -0,0 +1,61 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statusCache stores raw status pages in files for a short period.
+// Each plugin run is a separate process, so the cache lives in the plugin
+// work directory to be shared by runs scraping the same status page.
+type statusCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+func (c statusCache) path(key string) string {
+	return filepath.Join(c.Dir, fmt.Sprintf("mackerel-plugin-php-fpm-cache-%x", sha1.Sum([]byte(key))))
+}
+
+// Get returns the cached content of key if it is fresher than TTL.
+func (c statusCache) Get(key string) ([]byte, bool) {
+	if c.TTL <= 0 {
+		return nil, false
+	}
+	file := c.path(key)
+	fi, err := os.Stat(file)
+	if err != nil || time.Since(fi.ModTime()) >= c.TTL {
+		return nil, false
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Put stores b as the content of key.
+func (c statusCache) Put(key string, b []byte) error {
+	if c.TTL <= 0 {
+		return nil
+	}
+	f, err := os.CreateTemp(c.Dir, ".mackerel-plugin-php-fpm-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// rename(2) replaces the file atomically against concurrent runs.
+	return os.Rename(f.Name(), c.path(key))
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusCache(t *testing.T) {
+	c := statusCache{Dir: t.TempDir(), TTL: time.Minute}
+
+	_, ok := c.Get("http://localhost/status?json")
+	assert.False(t, ok)
+
+	assert.NoError(t, c.Put("http://localhost/status?json", []byte(`{"pool":"www"}`)))
+	b, ok := c.Get("http://localhost/status?json")
+	assert.True(t, ok)
+	assert.Equal(t, `{"pool":"www"}`, string(b))
+
+	_, ok = c.Get("http://localhost/other?json")
+	assert.False(t, ok)
+
+	old := time.Now().Add(-2 * time.Minute)
+	assert.NoError(t, os.Chtimes(c.path("http://localhost/status?json"), old, old))
+	_, ok = c.Get("http://localhost/status?json")
+	assert.False(t, ok, "expired entry should not be returned")
+}
+
+func TestStatusCache_Disabled(t *testing.T) {
+	c := statusCache{Dir: t.TempDir()}
+
+	assert.NoError(t, c.Put("http://localhost/status?json", []byte(`{}`)))
+	_, ok := c.Get("http://localhost/status?json")
+	assert.False(t, ok)
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"github.com/mackerelio/mackerel-agent-plugins/internal/errorformat"
+)
+
+// target returns where the status is fetched from, which is the socket with -socket.
+func (p PhpFpmPlugin) target() string {
+	if s := p.Socket.String(); s != "" {
+		return s
+	}
+	return p.URL
+}
+
+// fetchFailed writes err to fetch the status of p to stderr in the format and exits.
+func fetchFailed(format string, p PhpFpmPlugin, err error) {
+	errorformat.Exit(format, "php-fpm", p.target(), err)
+}
+
+// jsonErrorPlugin writes the failure to fetch the metrics in JSON instead of the plain text of the helper.
+type jsonErrorPlugin struct {
+	PhpFpmPlugin
+}
+
+// FetchMetrics interface for mackerelplugin
+func (p jsonErrorPlugin) FetchMetrics() (map[string]any, error) {
+	stat, err := p.PhpFpmPlugin.FetchMetrics()
+	if err != nil {
+		fetchFailed(errorformat.JSON, p.PhpFpmPlugin, err)
+	}
+	return stat, nil
+}
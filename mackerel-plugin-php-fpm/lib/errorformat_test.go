@@ -0,0 +1,38 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/errorformat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarget(t *testing.T) {
+	p := PhpFpmPlugin{URL: "http://localhost/status?json"}
+	assert.Equal(t, "http://localhost/status?json", p.target())
+
+	require.NoError(t, p.Socket.Set("unix:///run/php-fpm.sock"))
+	assert.Equal(t, "unix:///run/php-fpm.sock", p.target())
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, errorformat.IsTransient(&statusError{StatusCode: 502, Status: "502 Bad Gateway"}))
+	assert.False(t, errorformat.IsTransient(&statusError{StatusCode: 403, Status: "403 Forbidden"}))
+}
+
+func TestIsTransient_GetStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	p := PhpFpmPlugin{URL: ts.URL, Timeout: 5}
+	_, err := getStatus(p)
+	assert.Error(t, err)
+	assert.True(t, errorformat.IsTransient(err))
+}
@@ -3,10 +3,19 @@
 package mpphpfpm
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/textproto"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tomasen/fcgi_client"
@@ -16,6 +25,14 @@ import (
 type FastCGITransport struct {
 	Network string
 	Address string
+
+	// Conn is a connection to PHP-FPM, such as the one inherited from the parent process, used instead of dialing Address.
+	// PHP-FPM closes it after a request, so the transport can only be used once.
+	Conn net.Conn
+
+	// ScriptName is the path requested to PHP-FPM, which is pm.status_path for the status page.
+	// The path of the request URL is used if it is empty.
+	ScriptName string
 }
 
 func (*FastCGITransport) timeout(req *http.Request) time.Duration {
@@ -28,23 +45,22 @@ func (*FastCGITransport) timeout(req *http.Request) time.Duration {
 
 // RoundTrip implements the RoundTripper interface.
 func (t *FastCGITransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	c, err := fcgiclient.DialTimeout(t.Network, t.Address, t.timeout(req))
-	if err != nil {
-		return nil, err
-	}
-	defer c.Close()
-
 	params := make(map[string]string)
 	params["REQUEST_METHOD"] = req.Method
 	if req.ContentLength >= 0 {
 		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
 	}
 
+	u := *req.URL
+	if t.ScriptName != "" {
+		u.Path, u.RawPath = t.ScriptName, ""
+	}
+
 	// https://github.com/dreamcat4/php-fpm/blob/master/cgi/cgi_main.c#L781
-	params["PATH_INFO"] = req.URL.Path       // TODO(lufia): correct?
-	params["SCRIPT_NAME"] = req.URL.Path     // TODO(lufia): correct?
-	params["SCRIPT_FILENAME"] = req.URL.Path // TODO(lufia): correct?
-	params["REQUEST_URI"] = req.URL.RequestURI()
+	params["PATH_INFO"] = u.Path       // TODO(lufia): correct?
+	params["SCRIPT_NAME"] = u.Path     // TODO(lufia): correct?
+	params["SCRIPT_FILENAME"] = u.Path // TODO(lufia): correct?
+	params["REQUEST_URI"] = u.RequestURI()
 	params["QUERY_STRING"] = req.URL.RawQuery
 	params["SERVER_NAME"] = req.URL.Hostname()
 	params["SERVER_ADDR"] = req.URL.Port()
@@ -58,10 +74,30 @@ func (t *FastCGITransport) RoundTrip(req *http.Request) (*http.Response, error)
 	if ua := req.Header.Get("User-Agent"); ua != "" {
 		params["USER_AGENT"] = ua
 	}
-	resp, err := c.Request(params, req.Body)
+	for k, v := range req.Header {
+		params["HTTP_"+strings.ToUpper(strings.ReplaceAll(k, "-", "_"))] = strings.Join(v, ", ")
+	}
+	if req.Host != "" {
+		params["HTTP_HOST"] = req.Host
+	}
+	if t.Conn != nil {
+		return t.roundTripConn(req, params)
+	}
+
+	c, err := fcgiclient.DialTimeout(t.Network, t.Address, t.timeout(req))
 	if err != nil {
 		return nil, err
 	}
+	defer c.Close()
+
+	// fcgiclient doesn't set deadlines to the connection, so close it to abort reading on timeout.
+	stop := context.AfterFunc(req.Context(), func() { c.Close() })
+	defer stop()
+
+	resp, err := c.Request(params, req.Body)
+	if err != nil {
+		return nil, contextError(req, err)
+	}
 	body := resp.Body
 	defer body.Close()
 
@@ -69,8 +105,217 @@ func (t *FastCGITransport) RoundTrip(req *http.Request) (*http.Response, error)
 	// So contents of resp.Body should copy in memory.
 	var buf bytes.Buffer
 	if _, err := buf.ReadFrom(body); err != nil {
-		return nil, err
+		return nil, contextError(req, err)
 	}
 	resp.Body = io.NopCloser(&buf)
 	return resp, nil
 }
+
+// roundTripConn sends the request with params on Conn and reads the response.
+// fcgiclient can only dial an address, so the records are written and read here.
+// See https://fastcgi-archives.github.io/FastCGI_Specification.html for the protocol.
+func (t *FastCGITransport) roundTripConn(req *http.Request, params map[string]string) (*http.Response, error) {
+	defer t.Conn.Close()
+	if d, ok := req.Context().Deadline(); ok {
+		if err := t.Conn.SetDeadline(d); err != nil {
+			return nil, err
+		}
+	}
+	// close the connection to abort reading on cancel, as well as on timeout
+	stop := context.AfterFunc(req.Context(), func() { t.Conn.Close() })
+	defer stop()
+
+	var stdin []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		stdin = b
+	}
+	w := bufio.NewWriter(t.Conn)
+	// the role is the responder, and the flags don't keep the connection
+	if err := writeRecord(w, fcgiBeginRequest, []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, contextError(req, err)
+	}
+	if err := writeStream(w, fcgiParams, encodeParams(params)); err != nil {
+		return nil, contextError(req, err)
+	}
+	if err := writeStream(w, fcgiStdin, stdin); err != nil {
+		return nil, contextError(req, err)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, contextError(req, err)
+	}
+
+	stdout, err := readStdout(bufio.NewReader(t.Conn))
+	if err != nil {
+		return nil, contextError(req, err)
+	}
+	return readCGIResponse(stdout)
+}
+
+// FastCGI record types and the role of the request
+const (
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+)
+
+// protocolStatus of FCGI_END_REQUEST
+const (
+	fcgiRequestComplete = 0
+	fcgiCantMpxConn     = 1
+	fcgiOverloaded      = 2
+	fcgiUnknownRole     = 3
+)
+
+// fcgiProtocolStatusText describes the protocolStatus of the incomplete requests.
+var fcgiProtocolStatusText = map[byte]string{
+	fcgiCantMpxConn: "can't multiplex connections",
+	fcgiOverloaded:  "overloaded",
+	fcgiUnknownRole: "unknown role",
+}
+
+// fcgiRequestID is the ID of the only request on the connection.
+const fcgiRequestID = 1
+
+// writeRecord writes a record of typ with content, which must be shorter than 64KiB, to w.
+func writeRecord(w io.Writer, typ byte, content []byte) error {
+	h := [8]byte{1, typ}
+	binary.BigEndian.PutUint16(h[2:], fcgiRequestID)
+	binary.BigEndian.PutUint16(h[4:], uint16(len(content)))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// writeStream writes b as the records of typ, followed by the empty record closing the stream.
+func writeStream(w io.Writer, typ byte, b []byte) error {
+	for len(b) > 0 {
+		n := min(len(b), 0xffff)
+		if err := writeRecord(w, typ, b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return writeRecord(w, typ, nil)
+}
+
+// encodeParams returns params encoded as the name-value pairs of the params stream.
+func encodeParams(params map[string]string) []byte {
+	var b []byte
+	for k, v := range params {
+		b = appendParamLength(b, len(k))
+		b = appendParamLength(b, len(v))
+		b = append(b, k...)
+		b = append(b, v...)
+	}
+	return b
+}
+
+func appendParamLength(b []byte, n int) []byte {
+	if n < 0x80 {
+		return append(b, byte(n))
+	}
+	return binary.BigEndian.AppendUint32(b, uint32(n)|1<<31)
+}
+
+// readStdout reads the records from r until the end of the request, and returns the content of the stdout stream.
+// If the request is not complete, e.g. PHP-FPM is overloaded, it returns an error with the content of the stderr stream.
+func readStdout(r io.Reader) (*bytes.Buffer, error) {
+	var stdout, stderr bytes.Buffer
+	for {
+		var h [8]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint16(h[4:]))
+		content := make([]byte, n+int(h[6])) // followed by the padding
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+		switch h[1] {
+		case fcgiStdout:
+			stdout.Write(content[:n])
+		case fcgiStderr:
+			stderr.Write(content[:n])
+		case fcgiEndRequest:
+			if n < 8 {
+				return nil, fmt.Errorf("malformed FastCGI end request of %d bytes", n)
+			}
+			appStatus := binary.BigEndian.Uint32(content)
+			if status := content[4]; status != fcgiRequestComplete {
+				text, ok := fcgiProtocolStatusText[status]
+				if !ok {
+					text = fmt.Sprintf("protocol status %d", status)
+				}
+				err := fmt.Errorf("FastCGI request not complete: %s (app status %d)", text, appStatus)
+				if s := strings.TrimSpace(stderr.String()); s != "" {
+					err = fmt.Errorf("%w: %s", err, s)
+				}
+				return nil, err
+			}
+			return &stdout, nil
+		}
+	}
+}
+
+// readCGIResponse parses the response of a CGI script, whose status is given in the Status header.
+func readCGIResponse(r io.Reader) (*http.Response, error) {
+	br := bufio.NewReader(r)
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		Header:     http.Header(header),
+		Body:       io.NopCloser(br),
+	}
+	if s := resp.Header.Get("Status"); s != "" {
+		code, _, _ := strings.Cut(s, " ")
+		resp.StatusCode, err = strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("malformed status: %q", s)
+		}
+		resp.Status = s
+	}
+	resp.ContentLength, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return resp, nil
+}
+
+// fileConn returns the connection of the file descriptor fd inherited from the parent process.
+func fileConn(fd int) (net.Conn, error) {
+	f := os.NewFile(uintptr(fd), "fd"+strconv.Itoa(fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor: %d", fd)
+	}
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+// contextError returns the error of the request context instead of err if the context is done,
+// since the connection is closed by the context in that case.
+func contextError(req *http.Request, err error) error {
+	if cerr := req.Context().Err(); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -3,12 +3,15 @@
 package mpphpfpm
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"io"
 	"net"
 	"net/http"
 	"net/http/fcgi"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
@@ -155,3 +158,250 @@ func TestFCGITransportDialTimeout(t *testing.T) {
 		resp.Body.Close()
 	}
 }
+
+func TestGetStatus_FastCGITimeout(t *testing.T) {
+	// the server accepts connections but never responds
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, "failed to listen", err)
+	}
+	defer l.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	var socket SocketFlag
+	if err := socket.Set("tcp://" + l.Addr().String()); err != nil {
+		assert.FailNow(t, "failed to parse socket flag", err)
+	}
+	p := PhpFpmPlugin{
+		URL:     "http://localhost/status",
+		Timeout: 1,
+		Socket:  socket,
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := getStatus(p)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "getStatus didn't time out")
+	}
+}
+
+func TestGetStatus_FastCGIOverTCP(t *testing.T) {
+	ts, err := NewFastCGIServer("tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"pool":"www","total processes":50}`))
+	}))
+	if err != nil {
+		assert.FailNow(t, "failed to launch FastCGI server", err)
+	}
+	defer ts.Close()
+
+	var socket SocketFlag
+	if err := socket.Set("tcp://" + ts.Address); err != nil {
+		assert.FailNow(t, "failed to parse socket flag", err)
+	}
+	p := PhpFpmPlugin{
+		URL:     ts.URL,
+		Timeout: 5,
+		Socket:  socket,
+	}
+	status, err := getStatus(p)
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, 50, status.TotalProcesses)
+	}
+}
+
+func TestGetStatus_FastCGIStatusPath(t *testing.T) {
+	var requestURI string
+	ts, err := NewFastCGIServer("tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.URL.RequestURI()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"pool":"www","total processes":50}`))
+	}))
+	if err != nil {
+		assert.FailNow(t, "failed to launch FastCGI server", err)
+	}
+	defer ts.Close()
+
+	var socket SocketFlag
+	if err := socket.Set("tcp://" + ts.Address); err != nil {
+		assert.FailNow(t, "failed to parse socket flag", err)
+	}
+	p := PhpFpmPlugin{
+		URL:        ts.URL,
+		Timeout:    5,
+		Socket:     socket,
+		StatusPath: "/fpm-a1b2c3",
+	}
+	_, err = getStatus(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "/fpm-a1b2c3?json", requestURI)
+
+	// the path of -url by default
+	p.StatusPath = ""
+	p.URL = "http://localhost/fpm-status?json"
+	_, err = getStatus(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "/fpm-status?json", requestURI)
+}
+
+func TestFCGITransportConn(t *testing.T) {
+	dir := t.TempDir()
+	ts, err := NewFastCGIServer("unix", filepath.Join(dir, "php-fpm.sock"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	if err != nil {
+		assert.FailNow(t, "failed to launch FastCGI server", err)
+	}
+	defer ts.Close()
+
+	client := http.Client{
+		Transport: &FastCGITransport{Conn: inheritedConn(t, ts.Address)},
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		assert.FailNow(t, "failed to request a resource", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "pong", string(b))
+}
+
+func TestFCGITransportConn_Status(t *testing.T) {
+	dir := t.TempDir()
+	var requestURI string
+	ts, err := NewFastCGIServer("unix", filepath.Join(dir, "php-fpm.sock"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.URL.RequestURI()
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(bytes.Repeat([]byte("x"), 100000))
+	}))
+	if err != nil {
+		assert.FailNow(t, "failed to launch FastCGI server", err)
+	}
+	defer ts.Close()
+
+	client := http.Client{
+		Transport: &FastCGITransport{Conn: inheritedConn(t, ts.Address), ScriptName: "/fpm-status"},
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		assert.FailNow(t, "failed to request a resource", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "text/html", resp.Header.Get("Content-Type"))
+	assert.Len(t, b, 100000)
+	assert.Equal(t, "/fpm-status?json", requestURI)
+}
+
+func TestFCGITransportConn_Overloaded(t *testing.T) {
+	lis, err := net.Listen("unix", filepath.Join(t.TempDir(), "php-fpm.sock"))
+	if err != nil {
+		assert.FailNow(t, "failed to listen", err)
+	}
+	defer lis.Close()
+	go func() {
+		c, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		// read the request until the end of the stdin stream
+		for {
+			var h [8]byte
+			if _, err := io.ReadFull(c, h[:]); err != nil {
+				return
+			}
+			n := int(binary.BigEndian.Uint16(h[4:]))
+			if _, err := io.CopyN(io.Discard, c, int64(n+int(h[6]))); err != nil {
+				return
+			}
+			if h[1] == fcgiStdin && n == 0 {
+				break
+			}
+		}
+		writeRecord(c, fcgiStderr, []byte("server reached max_children setting\n"))
+		writeRecord(c, fcgiEndRequest, []byte{0, 0, 0, 0, fcgiOverloaded, 0, 0, 0})
+	}()
+
+	client := http.Client{
+		Transport: &FastCGITransport{Conn: inheritedConn(t, lis.Addr().String()), ScriptName: "/fpm-status"},
+	}
+	_, err = client.Get("http://localhost/status")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "overloaded")
+		assert.Contains(t, err.Error(), "server reached max_children setting")
+	}
+}
+
+func TestFetchStatusBody_ConnNoRetry(t *testing.T) {
+	dir := t.TempDir()
+	var requests int
+	ts, err := NewFastCGIServer("unix", filepath.Join(dir, "php-fpm.sock"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	}))
+	if err != nil {
+		assert.FailNow(t, "failed to launch FastCGI server", err)
+	}
+	defer ts.Close()
+
+	p := PhpFpmPlugin{
+		URL:     "http://localhost/status",
+		Timeout: 5,
+		Conn:    inheritedConn(t, ts.Address),
+	}
+	body, err := fetchStatusBody(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "<html></html>", string(body))
+	assert.Equal(t, 1, requests, "the connection of -fd is not reused for ?json")
+}
+
+// inheritedConn returns a connection to address through a duplicated file descriptor,
+// pretending the connection is inherited from the parent process.
+func inheritedConn(t *testing.T, address string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("unix", address)
+	if err != nil {
+		assert.FailNow(t, "failed to connect to FastCGI server", err)
+	}
+	f, err := c.(*net.UnixConn).File()
+	c.Close()
+	if err != nil {
+		assert.FailNow(t, "failed to get the file of the connection", err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		assert.FailNow(t, "failed to duplicate the file descriptor", err)
+	}
+	conn, err := fileConn(fd)
+	if err != nil {
+		assert.FailNow(t, "failed to get the connection of the file descriptor", err)
+	}
+	return conn
+}
@@ -4,26 +4,53 @@ package mpphpfpm
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
 	"strings"
 	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/golib/pluginutil"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/errorformat"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/plugincommon"
 )
 
+var logger = logging.GetLogger("metrics.plugin.php-fpm")
+
 // PhpFpmPlugin mackerel plugin
 type PhpFpmPlugin struct {
-	URL         string
-	Prefix      string
-	LabelPrefix string
-	Timeout     uint
-	Socket      SocketFlag
+	URL                string
+	Prefix             string
+	LabelPrefix        string
+	Timeout            uint
+	Socket             SocketFlag
+	CacheTTL           time.Duration
+	Header             []string
+	EmitScrapeDuration bool
+	ProcessesState     bool
+	Samples            int
+	SampleInterval     time.Duration
+	Interval           time.Duration
+	Format             string
+	MarkDown           bool
+	TLSMinVersion      uint16
+	Conn               net.Conn
+	StatusPath         string
+	SlowlogPath        string
+
+	// pool is shared between copies of the plugin to resolve poolPlaceholder in Prefix after fetching the status.
+	pool *string
 }
 
 // SocketFlag represents -socket flag.
@@ -114,6 +141,30 @@ func (p *SocketFlag) Transport() http.RoundTripper {
 	}
 }
 
+// transport returns http.RoundTripper to fetch the status page,
+// which is http.DefaultTransport with TLSMinVersion unless the status is fetched over FastCGI.
+func (p PhpFpmPlugin) transport() http.RoundTripper {
+	if p.Conn != nil {
+		return &FastCGITransport{Conn: p.Conn, ScriptName: p.StatusPath}
+	}
+	if t := p.Socket.Transport(); t != nil {
+		if t, ok := t.(*FastCGITransport); ok {
+			t.ScriptName = p.StatusPath
+		}
+		return t
+	}
+	if p.TLSMinVersion == 0 {
+		return nil // http.DefaultTransport
+	}
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil // http.DefaultTransport
+	}
+	t = t.Clone()
+	t.TLSClientConfig = &tls.Config{MinVersion: p.TLSMinVersion}
+	return t
+}
+
 // PhpFpmStatus struct for PhpFpmPlugin mackerel plugin
 type PhpFpmStatus struct {
 	Pool               string `json:"pool"`
@@ -131,16 +182,60 @@ type PhpFpmStatus struct {
 	MaxChildrenReached uint64 `json:"max children reached"`
 	SlowRequests       uint64 `json:"slow requests"`
 	MemoryPeak         uint64 `json:"memory peak"`
+
+	// Processes is only available with the "full" query.
+	Processes []PhpFpmProcess `json:"processes"`
+}
+
+// PhpFpmProcess is a process in the full status.
+type PhpFpmProcess struct {
+	PID   uint64 `json:"pid"`
+	State string `json:"state"`
+}
+
+// processStates are the states of processes always reported with -processes-state.
+var processStates = []string{"idle", "running", "reading_headers", "info", "finishing", "ending"}
+
+var normalizeMetricRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeMetricName(str string) string {
+	return normalizeMetricRe.ReplaceAllString(str, "_")
+}
+
+// processStateName converts a state such as "Reading headers" to a metric name.
+func processStateName(state string) string {
+	return normalizeMetricName(strings.ToLower(strings.TrimSpace(state)))
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
 func (p PhpFpmPlugin) MetricKeyPrefix() string {
-	return p.Prefix
+	if !strings.Contains(p.Prefix, poolPlaceholder) {
+		return p.Prefix
+	}
+	// graph definitions are output before the pool is fetched
+	pool := "#"
+	if p.pool != nil && *p.pool != "" {
+		pool = normalizeMetricName(*p.pool)
+	}
+	return strings.ReplaceAll(p.Prefix, poolPlaceholder, pool)
+}
+
+// poolPlaceholder in Prefix is replaced with the name of the pool.
+const poolPlaceholder = "{pool}"
+
+var validPrefixRe = regexp.MustCompile(`^[-a-zA-Z0-9_]+(\.[-a-zA-Z0-9_]+)*$`)
+
+// validatePrefix reports an error if prefix, whose placeholders are replaced, is not valid for metric keys.
+func validatePrefix(prefix string) error {
+	if !validPrefixRe.MatchString(strings.ReplaceAll(prefix, poolPlaceholder, "pool")) {
+		return fmt.Errorf("invalid metric key prefix: %s", prefix)
+	}
+	return nil
 }
 
 // GraphDefinition interface for mackerelplugin
 func (p PhpFpmPlugin) GraphDefinition() map[string]mp.Graphs {
-	return map[string]mp.Graphs{
+	graphs := map[string]mp.Graphs{
 		"processes": {
 			Label: p.LabelPrefix + " Processes",
 			Unit:  "integer",
@@ -195,13 +290,54 @@ func (p PhpFpmPlugin) GraphDefinition() map[string]mp.Graphs {
 			},
 		},
 	}
+	if p.ProcessesState {
+		graphs["processes_state"] = mp.Graphs{
+			Label: p.LabelPrefix + " Processes State",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: false, Stacked: true, Type: "uint64"},
+			},
+		}
+	}
+	if p.SlowlogPath != "" {
+		graphs["slowlog"] = mp.Graphs{
+			Label: p.LabelPrefix + " Slow Log",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "lines_added", Label: "Lines Added", Diff: true, Type: "uint64"},
+			},
+		}
+	}
+	if p.MarkDown {
+		graphs["pool"] = mp.Graphs{
+			Label: p.LabelPrefix + " Pool Up",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "up", Label: "Up", Diff: false, Type: "uint64"},
+			},
+		}
+	}
+	if p.EmitScrapeDuration {
+		graphs["plugin"] = mp.Graphs{
+			Label: p.LabelPrefix + " Plugin Scrape Duration",
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "scrape_duration_ms", Label: "Duration", Diff: false, Type: "float64"},
+			},
+		}
+	}
+	if p.Interval > 0 {
+		plugincommon.ScaleHelperDiffs(graphs, p.Interval)
+	}
+	return graphs
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p PhpFpmPlugin) FetchMetrics() (map[string]any, error) {
+	start := time.Now()
 	status, err := getStatus(p)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to fetch PHP-FPM metrics: %s", err) // nolint
+		return p.down(err)
 	}
 
 	result := map[string]any{
@@ -220,23 +356,209 @@ func (p PhpFpmPlugin) FetchMetrics() (map[string]any, error) {
 	if status.MemoryPeak > 0 {
 		result["memory_peak"] = status.MemoryPeak
 	}
+	if p.Samples > 1 {
+		if err := p.sample(result, status); err != nil {
+			return p.down(err)
+		}
+	}
+	if p.pool != nil {
+		*p.pool = status.Pool
+	}
+	if p.ProcessesState {
+		for _, s := range processStates {
+			result["processes_state."+s] = uint64(0)
+		}
+		for _, proc := range status.Processes {
+			key := "processes_state." + processStateName(proc.State)
+			n, _ := result[key].(uint64)
+			result[key] = n + 1
+		}
+	}
+	if p.SlowlogPath != "" {
+		n, err := countSlowlogLines(p.SlowlogPath, slowlogStatePath(pluginutil.PluginWorkDir(), p.SlowlogPath))
+		if err != nil {
+			logger.Warningf("Failed to read the slow log: %s", err)
+		} else {
+			result["lines_added"] = n
+		}
+	}
+	if p.MarkDown {
+		result["up"] = uint64(1)
+	}
+	if p.EmitScrapeDuration {
+		result["scrape_duration_ms"] = float64(time.Since(start)) / float64(time.Millisecond)
+	}
 
 	return result, nil
 }
 
+// down returns the result of FetchMetrics when any of the samples of the status can't be fetched,
+// which is only up=0 with -mark-down.
+func (p PhpFpmPlugin) down(err error) (map[string]any, error) {
+	if p.MarkDown {
+		logger.Errorf("Failed to fetch PHP-FPM metrics: %s", err)
+		return map[string]any{"up": uint64(0)}, nil
+	}
+	return nil, fmt.Errorf("Failed to fetch PHP-FPM metrics: %s", err) // nolint
+}
+
+// sample fetches the status p.Samples-1 more times at p.SampleInterval, bypassing the cache.
+// It replaces the gauges in result with their averages over the samples including status,
+// the high-watermarks with their maximums and the counters with the latest values.
+func (p PhpFpmPlugin) sample(result map[string]any, status *PhpFpmStatus) error {
+	statuses := []*PhpFpmStatus{status}
+	for i := 1; i < p.Samples; i++ {
+		time.Sleep(p.SampleInterval)
+		body, err := fetchStatusBody(p)
+		if err != nil {
+			return err
+		}
+		s, err := p.parseStatus(body)
+		if err != nil {
+			return err
+		}
+		statuses = append(statuses, s)
+	}
+
+	var total, active, idle, listenQueue, listenQueueLen float64
+	var maxActive, maxListenQueue, memoryPeak uint64
+	for _, s := range statuses {
+		total += float64(s.TotalProcesses)
+		active += float64(s.ActiveProcesses)
+		idle += float64(s.IdleProcesses)
+		listenQueue += float64(s.ListenQueue)
+		listenQueueLen += float64(s.ListenQueueLen)
+		maxActive = max(maxActive, s.MaxActiveProcesses)
+		maxListenQueue = max(maxListenQueue, s.MaxListenQueue)
+		memoryPeak = max(memoryPeak, s.MemoryPeak)
+	}
+	n := float64(len(statuses))
+	result["total_processes"] = total / n
+	result["active_processes"] = active / n
+	result["idle_processes"] = idle / n
+	result["listen_queue"] = listenQueue / n
+	result["listen_queue_len"] = listenQueueLen / n
+	result["max_active_processes"] = maxActive
+	result["max_listen_queue"] = maxListenQueue
+	if memoryPeak > 0 {
+		result["memory_peak"] = memoryPeak
+	}
+
+	last := statuses[len(statuses)-1]
+	result["max_children_reached"] = last.MaxChildrenReached
+	result["slow_requests"] = last.SlowRequests
+	result["slow_requests_delta"] = last.SlowRequests
+	return nil
+}
+
 func getStatus(p PhpFpmPlugin) (*PhpFpmStatus, error) {
-	url := p.URL
+	cache := statusCache{Dir: pluginutil.PluginWorkDir(), TTL: p.CacheTTL}
+	// the connection inherited by -fd can't be identified across runs, so the status read from it isn't cached
+	if p.Conn != nil {
+		cache.TTL = 0
+	}
+	// every option changing the status page is part of the key, joined by newlines which can't appear in them
+	key := strings.Join(append([]string{p.Socket.String(), p.StatusPath, p.URL, p.Format}, p.Header...), "\n")
+	body, ok := cache.Get(key)
+	if ok {
+		logger.Debugf("use cached status of %s", p.URL)
+	} else {
+		var err error
+		body, err = fetchStatusBody(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := cache.Put(key, body); err != nil {
+			logger.Warningf("failed to cache status: %s", err)
+		}
+	}
+
+	return p.parseStatus(body)
+}
+
+// parseStatus parses body in the format of the status page.
+func (p PhpFpmPlugin) parseStatus(body []byte) (*PhpFpmStatus, error) {
+	if p.Format == formatText {
+		return parseTextStatus(body)
+	}
+	var status *PhpFpmStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// dumpStatus writes the parsed status to w as indented JSON, bypassing the cache.
+// The processes are written with all of their fields.
+func dumpStatus(w io.Writer, p PhpFpmPlugin) error {
+	body, err := fetchStatusBody(p)
+	if err != nil {
+		return err
+	}
+	if p.Format == formatText {
+		status, err := parseTextStatus(body)
+		if err != nil {
+			return err
+		}
+		return encodeIndent(w, status)
+	}
+	var status struct {
+		PhpFpmStatus
+		Processes []map[string]any `json:"processes,omitempty"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return err
+	}
+	return encodeIndent(w, status)
+}
+
+func encodeIndent(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func fetchStatusBody(p PhpFpmPlugin) ([]byte, error) {
+	body, ctype, err := fetchStatus(p, p.URL)
+	if err != nil {
+		return nil, err
+	}
+	if p.Format != formatText && ctype != "" && !isJSONContentType(ctype) {
+		// Some reverse proxies negotiate the status page to text/html
+		// unless the query string asks PHP-FPM for JSON explicitly.
+		// The connection of -fd is closed after the first request, so it is not retried.
+		if u, ok := withQuery(p.URL, "json"); ok && p.Conn == nil {
+			logger.Debugf("status page returned %q, retrying with %s", ctype, u)
+			body, _, err = fetchStatus(p, u)
+			if err != nil {
+				return nil, err
+			}
+			logger.Debugf("fetched status from %s", u)
+		}
+	} else {
+		logger.Debugf("fetched status from %s", p.URL)
+	}
+	return body, nil
+}
+
+func fetchStatus(p PhpFpmPlugin, url string) ([]byte, string, error) {
 	timeout := time.Duration(time.Duration(p.Timeout) * time.Second)
 	client := http.Client{
 		Timeout:   timeout,
-		Transport: p.Socket.Transport(),
+		Transport: p.transport(),
 	}
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	req.Header.Set("User-Agent", "mackerel-plugin-php-fpm")
+	if p.Format == formatText {
+		req.Header.Set("Accept", "text/plain")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+	plugincommon.SetHeaders(req, p.Header)
 	if timeout > 0 {
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
@@ -245,43 +567,169 @@ func getStatus(p PhpFpmPlugin) (*PhpFpmStatus, error) {
 
 	res, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer res.Body.Close()
 
+	// the status code of FastCGI responses is 0 unless the Status header comes first
+	if res.StatusCode >= 400 {
+		return nil, "", &statusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	return body, res.Header.Get("Content-Type"), nil
+}
 
-	var status *PhpFpmStatus
-	if err := json.Unmarshal(body, &status); err != nil {
-		return nil, err
+// statusError is returned by fetchStatus when the status page responds with an error status.
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status: " + e.Status
+}
+
+// HTTPStatusCode returns the status code for errorformat.IsTransient.
+func (e *statusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+func isJSONContentType(ctype string) bool {
+	mediatype, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
 	}
+	return mediatype == "application/json" || strings.HasSuffix(mediatype, "+json")
+}
 
-	return status, nil
+// withQuery returns rawURL with name appended to its query string.
+// It reports false if rawURL already has name or can't be parsed.
+func withQuery(rawURL, name string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	if u.Query().Has(name) {
+		return "", false
+	}
+	if u.RawQuery == "" {
+		u.RawQuery = name
+	} else {
+		u.RawQuery += "&" + name
+	}
+	return u.String(), true
 }
 
 // Do the plugin
 func Do() {
 	optURL := flag.String("url", "http://localhost/status?json", "PHP-FPM status page URL")
-	optPrefix := flag.String("metric-key-prefix", "php-fpm", "Metric key prefix")
+	optPrefix := flag.String("metric-key-prefix", "php-fpm", "Metric key prefix, in which {pool} is replaced with the name of the pool")
 	optLabelPrefix := flag.String("metric-label-prefix", "PHP-FPM", "Metric label prefix")
 	optTimeout := flag.Uint("timeout", 5, "Timeout")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optCacheTTL := flag.Duration("cache-ttl", 0, "Reuse the status fetched by another run within this `duration` (0 disables the cache)")
+	optStrict := flag.Bool("strict", false, "Exit with an error if the tempfile is not writable")
+	optQuiet := flag.Bool("quiet", false, "Suppress all non-fatal log messages")
+	optSamples := flag.Int("samples", 1, "Number of times to fetch the status to average the gauges")
+	optSampleInterval := flag.Duration("sample-interval", time.Second, "Interval between the samples")
+	optProcessesState := flag.Bool("processes-state", false, "Also emit the number of processes in each state, which requests the full status")
+	optEmitScrapeDuration := flag.Bool("emit-scrape-duration", false, "Also emit the time taken to fetch the metrics")
+	optFormat := flag.String("format", formatJSON, "`format` of the status page (json or text)")
+	optMarkDown := flag.Bool("mark-down", false, "Emit pool.up as 0 instead of failing when the status can't be fetched, and 1 otherwise")
+	optErrorFormat := flag.String("error-format", errorformat.Text, "`format` of the error written to stderr when the metrics can't be fetched (text or json)")
+	optDump := flag.Bool("dump", false, "Print the parsed status as JSON to stderr and exit without printing metrics")
+	optInterval := flag.Duration("interval", 0, "Report the metrics taking differences per this `duration` instead of per minute, e.g. 1s for per second")
+	optTLSMinVersion := flag.String("tls-min-version", "1.2", "Minimum TLS `version` of the status page over HTTPS (1.0, 1.1, 1.2 or 1.3)")
+	var optHeader plugincommon.HeaderFlag
+	flag.Var(&optHeader, "header", "Set http header (e.g. \"X-Api-Key: secret\"), can be specified multiple times")
 	var socketFlag SocketFlag
 	flag.Var(&socketFlag, "socket", "Unix domain socket `path or URL`")
+	optStatusPath := flag.String("status-path", "", "`path` of the status page in PHP-FPM (pm.status_path) requested over FastCGI with -socket or -fd (default: the path of -url)")
+	optSlowlogPath := flag.String("slowlog-path", "", "Also emit the lines added to the slow log at the `path` (slowlog in the pool configuration)")
+	optFD := flag.Int("fd", -1, "Fetch the status over FastCGI on the connection of the file `descriptor` inherited from the parent process instead of -socket")
 	flag.Parse()
 
+	if *optFormat != formatJSON && *optFormat != formatText {
+		log.Fatalf("unknown format: %s", *optFormat)
+	}
+	if *optErrorFormat != errorformat.Text && *optErrorFormat != errorformat.JSON {
+		log.Fatalf("unknown error format: %s", *optErrorFormat)
+	}
+
+	tlsMinVersion, err := plugincommon.ParseTLSVersion(*optTLSMinVersion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *optQuiet {
+		logging.SetLogLevel(logging.CRITICAL)
+	}
+
 	p := PhpFpmPlugin{
-		URL:         *optURL,
-		Prefix:      *optPrefix,
-		LabelPrefix: *optLabelPrefix,
-		Timeout:     *optTimeout,
-		Socket:      socketFlag,
+		URL:                *optURL,
+		Prefix:             *optPrefix,
+		LabelPrefix:        *optLabelPrefix,
+		Timeout:            *optTimeout,
+		Socket:             socketFlag,
+		CacheTTL:           *optCacheTTL,
+		Header:             optHeader,
+		EmitScrapeDuration: *optEmitScrapeDuration,
+		ProcessesState:     *optProcessesState,
+		Samples:            *optSamples,
+		SampleInterval:     *optSampleInterval,
+		Interval:           *optInterval,
+		Format:             *optFormat,
+		MarkDown:           *optMarkDown,
+		TLSMinVersion:      tlsMinVersion,
+		StatusPath:         *optStatusPath,
+		SlowlogPath:        *optSlowlogPath,
+		pool:               new(string),
+	}
+	if err := validatePrefix(p.Prefix); err != nil {
+		log.Fatalln(err)
+	}
+	if p.MarkDown && strings.Contains(p.Prefix, poolPlaceholder) {
+		// the pool is unknown when its status can't be fetched
+		log.Fatalf("-mark-down can't be used with %s in -metric-key-prefix", poolPlaceholder)
+	}
+	if *optFD >= 0 {
+		// PHP-FPM closes the connection after a request
+		if p.Samples > 1 {
+			log.Fatalln("-fd can't be used with -samples")
+		}
+		conn, err := fileConn(*optFD)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		p.Conn = conn
 	}
-	helper := mp.NewMackerelPlugin(p)
+	if p.ProcessesState {
+		if u, ok := withQuery(p.URL, "full"); ok {
+			p.URL = u
+		}
+	}
+	if *optDump {
+		if err := dumpStatus(os.Stderr, p); err != nil {
+			fetchFailed(*optErrorFormat, p, err)
+		}
+		return
+	}
+	var plugin mp.Plugin = p
+	if *optErrorFormat == errorformat.JSON {
+		plugin = jsonErrorPlugin{p}
+	}
+	helper := mp.NewMackerelPlugin(plugin)
 	helper.Tempfile = *optTempfile
+	if err := plugincommon.CheckTempfile(helper.Tempfile); err != nil {
+		if *optStrict {
+			log.Fatalf("tempfile is not writable: %s", err)
+		}
+		logger.Warningf("tempfile is not writable, so metrics taking differences will be wrong: %s", err)
+	}
 
 	helper.Run()
 }
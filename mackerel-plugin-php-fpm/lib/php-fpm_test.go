@@ -3,7 +3,12 @@
 package mpphpfpm
 
 import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
@@ -90,6 +95,333 @@ func TestGetStatus_MemoryPeak(t *testing.T) {
 	assert.EqualValues(t, 1280000, status.MemoryPeak)
 }
 
+func TestFetchMetrics_ProcessesState(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	jsonStr := `{
+    "pool":"www",
+    "idle processes":2,
+    "active processes":2,
+    "total processes":4,
+    "processes":[
+      {"pid":100,"state":"Idle"},
+      {"pid":101,"state":"Running"},
+      {"pid":102,"state":"Reading headers"},
+      {"pid":103,"state":"Idle"}
+    ]
+  }`
+
+	httpmock.RegisterResponder("GET", "http://httpmock/status?json&full",
+		httpmock.NewStringResponder(200, jsonStr))
+
+	p := PhpFpmPlugin{
+		URL:            "http://httpmock/status?json&full",
+		Prefix:         "php-fpm",
+		Timeout:        5,
+		ProcessesState: true,
+	}
+	stat, err := p.FetchMetrics()
+
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, stat["processes_state.idle"])
+	assert.EqualValues(t, 1, stat["processes_state.running"])
+	assert.EqualValues(t, 1, stat["processes_state.reading_headers"])
+	assert.EqualValues(t, 0, stat["processes_state.finishing"])
+	assert.Contains(t, stat, "processes_state.finishing")
+}
+
+func TestDumpStatus(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	jsonStr := `{
+    "pool":"www",
+    "active processes":1,
+    "processes":[
+      {"pid":100,"state":"Running","request uri":"/index.php"}
+    ]
+  }`
+	httpmock.RegisterResponder("GET", "http://httpmock/status?json&full",
+		httpmock.NewStringResponder(200, jsonStr))
+
+	p := PhpFpmPlugin{
+		URL:     "http://httpmock/status?json&full",
+		Timeout: 5,
+	}
+	var buf bytes.Buffer
+	require.NoError(t, dumpStatus(&buf, p))
+	assert.Contains(t, buf.String(), `"pool": "www"`)
+	assert.Contains(t, buf.String(), `"active processes": 1`)
+	assert.Contains(t, buf.String(), `"request uri": "/index.php"`)
+}
+
+func TestFetchMetrics_FormatText(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "text/plain", req.Header.Get("Accept"))
+			resp := httpmock.NewStringResponse(200, testTextStatus)
+			resp.Header.Set("Content-Type", "text/plain")
+			return resp, nil
+		})
+
+	p := PhpFpmPlugin{
+		URL:     "http://httpmock/status",
+		Prefix:  "php-fpm",
+		Timeout: 5,
+		Format:  formatText,
+	}
+	stat, err := p.FetchMetrics()
+
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, stat["active_processes"])
+	assert.EqualValues(t, 128, stat["listen_queue_len"])
+	assert.EqualValues(t, 7, stat["slow_requests"])
+	assert.Equal(t, 1, httpmock.GetTotalCallCount(), "text status should not be retried with json")
+}
+
+func TestGetStatus_FallbackToJSONQuery(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	jsonStr := `{
+    "pool":"www",
+    "process manager":"dynamic",
+    "idle processes":40,
+    "active processes":10,
+    "total processes":50
+  }`
+
+	html := httpmock.NewStringResponse(200, "<html><body>pool: www</body></html>")
+	html.Header.Set("Content-Type", "text/html")
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		httpmock.ResponderFromResponse(html))
+	res := httpmock.NewStringResponse(200, jsonStr)
+	res.Header.Set("Content-Type", "application/json")
+	httpmock.RegisterResponderWithQuery("GET", "http://httpmock/status", "json",
+		httpmock.ResponderFromResponse(res))
+
+	p := PhpFpmPlugin{
+		URL:     "http://httpmock/status",
+		Prefix:  "php-fpm",
+		Timeout: 5,
+	}
+	status, err := getStatus(p)
+
+	require.NoError(t, err)
+	assert.EqualValues(t, 50, status.TotalProcesses)
+	assert.EqualValues(t, 2, httpmock.GetTotalCallCount())
+}
+
+func TestGetStatus_Header(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("X-Api-Gateway-Key") != "secret" {
+				return httpmock.NewStringResponse(403, "forbidden"), nil
+			}
+			return httpmock.NewStringResponse(200, `{"pool":"www","total processes":50}`), nil
+		})
+
+	p := PhpFpmPlugin{
+		URL:     "http://httpmock/status",
+		Prefix:  "php-fpm",
+		Timeout: 5,
+		Header:  []string{"X-Api-Gateway-Key: secret"},
+	}
+	status, err := getStatus(p)
+
+	require.NoError(t, err)
+	assert.EqualValues(t, 50, status.TotalProcesses)
+}
+
+func TestFetchMetrics_Samples(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	samples := []string{
+		`{"pool":"www","active processes":1,"idle processes":9,"total processes":10,"max active processes":8,"slow requests":3}`,
+		`{"pool":"www","active processes":6,"idle processes":4,"total processes":10,"max active processes":8,"slow requests":4}`,
+		`{"pool":"www","active processes":2,"idle processes":8,"total processes":10,"max active processes":9,"slow requests":5}`,
+	}
+	var n int
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(200, samples[n])
+			n++
+			return resp, nil
+		})
+
+	p := PhpFpmPlugin{
+		URL:     "http://httpmock/status",
+		Prefix:  "php-fpm",
+		Timeout: 5,
+		Samples: 3,
+	}
+	stat, err := p.FetchMetrics()
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.EqualValues(t, 3, stat["active_processes"])
+	assert.EqualValues(t, 7, stat["idle_processes"])
+	assert.EqualValues(t, 10, stat["total_processes"])
+	assert.EqualValues(t, 9, stat["max_active_processes"])
+	assert.EqualValues(t, 5, stat["slow_requests"])
+}
+
+func TestGetStatus_CacheHeader(t *testing.T) {
+	t.Setenv("MACKEREL_PLUGIN_WORKDIR", t.TempDir())
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewStringResponse(200, fmt.Sprintf(`{"pool":%q,"total processes":10}`, req.Header.Get("X-Pool"))), nil
+		})
+
+	for _, pool := range []string{"www", "api"} {
+		p := PhpFpmPlugin{
+			URL:      "http://httpmock/status",
+			Timeout:  5,
+			CacheTTL: time.Minute,
+			Header:   []string{"X-Pool: " + pool},
+		}
+		status, err := getStatus(p)
+		require.NoError(t, err)
+		assert.Equal(t, pool, status.Pool, "the status must not be shared between different headers")
+	}
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+}
+
+func TestMetricKeyPrefix_Pool(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		httpmock.NewStringResponder(200, `{"pool":"www.example","total processes":50}`))
+
+	p := PhpFpmPlugin{
+		URL:     "http://httpmock/status",
+		Prefix:  "php-fpm.{pool}",
+		Timeout: 5,
+		pool:    new(string),
+	}
+	assert.Equal(t, "php-fpm.#", p.MetricKeyPrefix())
+
+	_, err := p.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, "php-fpm.www_example", p.MetricKeyPrefix())
+}
+
+func TestValidatePrefix(t *testing.T) {
+	assert.NoError(t, validatePrefix("php-fpm"))
+	assert.NoError(t, validatePrefix("php-fpm.{pool}"))
+	assert.NoError(t, validatePrefix("{pool}_fpm"))
+	assert.Error(t, validatePrefix("php-fpm.{pool"))
+	assert.Error(t, validatePrefix("php fpm"))
+	assert.Error(t, validatePrefix("php-fpm."))
+}
+
+func TestFetchMetrics_EmitScrapeDuration(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		httpmock.NewStringResponder(200, `{"pool":"www","total processes":50}`))
+
+	p := PhpFpmPlugin{
+		URL:                "http://httpmock/status",
+		Prefix:             "php-fpm",
+		Timeout:            5,
+		EmitScrapeDuration: true,
+	}
+	stat, err := p.FetchMetrics()
+
+	require.NoError(t, err)
+	assert.Contains(t, stat, "scrape_duration_ms")
+	assert.Contains(t, p.GraphDefinition(), "plugin")
+}
+
+func TestGraphDefinition_Interval(t *testing.T) {
+	p := PhpFpmPlugin{Prefix: "php-fpm", Interval: time.Second}
+
+	metrics := p.GraphDefinition()["slow_requests"].Metrics
+	assert.Zero(t, metrics[0].Scale)
+	assert.InDelta(t, 1.0/60, metrics[1].Scale, 1e-9)
+	assert.Equal(t, "float64", metrics[1].Type)
+}
+
+func TestFetchMetrics_MarkDown(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		httpmock.NewStringResponder(200, `{"pool":"www","total processes":50}`))
+	httpmock.RegisterResponder("GET", "http://httpmock/down",
+		httpmock.NewStringResponder(502, `<html>Bad Gateway</html>`))
+
+	p := PhpFpmPlugin{
+		URL:      "http://httpmock/status",
+		Prefix:   "php-fpm",
+		Timeout:  5,
+		MarkDown: true,
+	}
+	stat, err := p.FetchMetrics()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, stat["up"])
+	assert.EqualValues(t, 50, stat["total_processes"])
+	assert.Contains(t, p.GraphDefinition(), "pool")
+
+	p.URL = "http://httpmock/down"
+	stat, err = p.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"up": uint64(0)}, stat)
+}
+
+func TestFetchMetrics_MarkDownSamples(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	var n int
+	httpmock.RegisterResponder("GET", "http://httpmock/status",
+		func(req *http.Request) (*http.Response, error) {
+			n++
+			if n > 1 {
+				return httpmock.NewStringResponse(502, `<html>Bad Gateway</html>`), nil
+			}
+			return httpmock.NewStringResponse(200, `{"pool":"www","total processes":50}`), nil
+		})
+
+	p := PhpFpmPlugin{
+		URL:      "http://httpmock/status",
+		Prefix:   "php-fpm",
+		Timeout:  5,
+		Samples:  3,
+		MarkDown: true,
+	}
+	stat, err := p.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, map[string]any{"up": uint64(0)}, stat)
+}
+
+func TestTransport_TLSMinVersion(t *testing.T) {
+	p := PhpFpmPlugin{TLSMinVersion: tls.VersionTLS13}
+	tr, ok := p.transport().(*http.Transport)
+	require.True(t, ok)
+	assert.EqualValues(t, tls.VersionTLS13, tr.TLSClientConfig.MinVersion)
+
+	var socket SocketFlag
+	require.NoError(t, socket.Set("unix:///run/php-fpm.sock"))
+	p.Socket = socket
+	assert.IsType(t, &FastCGITransport{}, p.transport())
+}
+
 func TestSocketFlag_Set(t *testing.T) {
 	tests := []struct {
 		Name string
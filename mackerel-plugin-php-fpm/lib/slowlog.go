@@ -0,0 +1,101 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// slowlogState is the position in the slow log up to which the previous run read.
+type slowlogState struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+	// Lines is the number of the lines read since the first run.
+	Lines uint64 `json:"lines"`
+}
+
+// slowlogStatePath returns the file in dir to keep the state of the slow log at path between runs.
+func slowlogStatePath(dir, path string) string {
+	return filepath.Join(dir, fmt.Sprintf("mackerel-plugin-php-fpm-slowlog-%x", sha1.Sum([]byte(path))))
+}
+
+// countSlowlogLines returns the number of the lines added to the slow log at path since the first run,
+// reading only the lines appended after the offset kept in the file at statePath.
+// The first run starts at the end of the slow log. When the slow log is replaced or shrinks on rotation,
+// it is read from the beginning.
+func countSlowlogLines(path, statePath string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var inode uint64
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		inode = st.Ino
+	}
+
+	var state slowlogState
+	b, err := os.ReadFile(statePath)
+	switch {
+	case os.IsNotExist(err):
+		state = slowlogState{Inode: inode, Offset: fi.Size()}
+	case err != nil:
+		return 0, err
+	default:
+		if err := json.Unmarshal(b, &state); err != nil {
+			return 0, err
+		}
+		if state.Inode != inode || fi.Size() < state.Offset {
+			state.Inode, state.Offset = inode, 0
+		}
+	}
+
+	if _, err := f.Seek(state.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	appended, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	// a line being written is counted by the next run
+	if i := bytes.LastIndexByte(appended, '\n'); i >= 0 {
+		state.Lines += uint64(bytes.Count(appended[:i+1], []byte{'\n'}))
+		state.Offset += int64(i + 1)
+	}
+	if err := saveSlowlogState(statePath, state); err != nil {
+		return 0, err
+	}
+	return state.Lines, nil
+}
+
+func saveSlowlogState(path string, state slowlogState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".mackerel-plugin-php-fpm-slowlog-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// rename(2) replaces the file atomically against concurrent runs.
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountSlowlogLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "www-slow.log")
+	state := slowlogStatePath(dir, path)
+	appendLines := func(s string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := countSlowlogLines(path, state)
+	assert.Error(t, err, "no slow log")
+
+	// the lines before the first run are not counted
+	appendLines("[15-Oct-2026 10:00:00]  [pool www] pid 1\n")
+	n, err := countSlowlogLines(path, state)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, n)
+
+	// a line being written is counted when it is terminated
+	appendLines("script_filename = /var/www/index.php\n\n[15-Oct-2026 10:01:00]")
+	n, err = countSlowlogLines(path, state)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+	appendLines("  [pool www] pid 2\n")
+	n, err = countSlowlogLines(path, state)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, n)
+
+	// truncated by copytruncate of logrotate
+	assert.NoError(t, os.Truncate(path, 0))
+	appendLines("[15-Oct-2026 10:02:00]  [pool www] pid 3\n")
+	n, err = countSlowlogLines(path, state)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, n)
+
+	// replaced with a new file longer than the offset
+	assert.NoError(t, os.Rename(path, path+".1"))
+	appendLines("[15-Oct-2026 10:03:00]  [pool www] pid 4\nscript_filename = /var/www/index.php\n\n")
+	n, err = countSlowlogLines(path, state)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, n)
+}
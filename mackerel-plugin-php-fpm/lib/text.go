@@ -0,0 +1,90 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON = "json"
+	formatText = "text"
+)
+
+// textStatusFields maps the keys of the text status page to the fields of PhpFpmStatus.
+// "start time" is a formatted date in the text status page, so it is not parsed.
+var textStatusFields = map[string]func(*PhpFpmStatus) *uint64{
+	"start since":          func(s *PhpFpmStatus) *uint64 { return &s.StartSince },
+	"accepted conn":        func(s *PhpFpmStatus) *uint64 { return &s.AcceptedConn },
+	"listen queue":         func(s *PhpFpmStatus) *uint64 { return &s.ListenQueue },
+	"max listen queue":     func(s *PhpFpmStatus) *uint64 { return &s.MaxListenQueue },
+	"listen queue len":     func(s *PhpFpmStatus) *uint64 { return &s.ListenQueueLen },
+	"idle processes":       func(s *PhpFpmStatus) *uint64 { return &s.IdleProcesses },
+	"active processes":     func(s *PhpFpmStatus) *uint64 { return &s.ActiveProcesses },
+	"total processes":      func(s *PhpFpmStatus) *uint64 { return &s.TotalProcesses },
+	"max active processes": func(s *PhpFpmStatus) *uint64 { return &s.MaxActiveProcesses },
+	"max children reached": func(s *PhpFpmStatus) *uint64 { return &s.MaxChildrenReached },
+	"slow requests":        func(s *PhpFpmStatus) *uint64 { return &s.SlowRequests },
+	"memory peak":          func(s *PhpFpmStatus) *uint64 { return &s.MemoryPeak },
+}
+
+// parseTextStatus parses the default text status page, which consists of "key: value" lines.
+// In the full status, each process follows a line of asterisks.
+func parseTextStatus(body []byte) (*PhpFpmStatus, error) {
+	var status PhpFpmStatus
+	var found bool
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "***") {
+			status.Processes = append(status.Processes, PhpFpmProcess{})
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if n := len(status.Processes); n > 0 {
+			proc := &status.Processes[n-1]
+			switch key {
+			case "pid":
+				proc.PID, _ = strconv.ParseUint(value, 10, 64)
+			case "state":
+				proc.State = value
+			}
+			continue
+		}
+
+		switch key {
+		case "pool":
+			status.Pool = value
+			found = true
+		case "process manager":
+			status.ProcessManager = value
+		default:
+			field, ok := textStatusFields[key]
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %q", key, value)
+			}
+			*field(&status) = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("pool is not found in the text status page")
+	}
+	return &status, nil
+}
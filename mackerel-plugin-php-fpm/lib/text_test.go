@@ -0,0 +1,65 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTextStatus = `pool:                 www
+process manager:      dynamic
+start time:           15/Oct/2026:09:12:45 +0000
+start since:          3600
+accepted conn:        1234
+listen queue:         1
+max listen queue:     5
+listen queue len:     128
+idle processes:       3
+active processes:     2
+total processes:      5
+max active processes: 4
+max children reached: 0
+slow requests:        7
+
+************************
+pid:                  100
+state:                Idle
+start time:           15/Oct/2026:09:12:45 +0000
+request uri:          /index.php
+
+************************
+pid:                  101
+state:                Running
+start time:           15/Oct/2026:09:12:45 +0000
+request uri:          /status
+`
+
+func TestParseTextStatus(t *testing.T) {
+	status, err := parseTextStatus([]byte(testTextStatus))
+	require.NoError(t, err)
+
+	assert.Equal(t, "www", status.Pool)
+	assert.Equal(t, "dynamic", status.ProcessManager)
+	assert.EqualValues(t, 3600, status.StartSince)
+	assert.EqualValues(t, 1234, status.AcceptedConn)
+	assert.EqualValues(t, 1, status.ListenQueue)
+	assert.EqualValues(t, 5, status.MaxListenQueue)
+	assert.EqualValues(t, 128, status.ListenQueueLen)
+	assert.EqualValues(t, 3, status.IdleProcesses)
+	assert.EqualValues(t, 2, status.ActiveProcesses)
+	assert.EqualValues(t, 5, status.TotalProcesses)
+	assert.EqualValues(t, 4, status.MaxActiveProcesses)
+	assert.EqualValues(t, 7, status.SlowRequests)
+	assert.Equal(t, []PhpFpmProcess{{PID: 100, State: "Idle"}, {PID: 101, State: "Running"}}, status.Processes)
+}
+
+func TestParseTextStatus_Invalid(t *testing.T) {
+	_, err := parseTextStatus([]byte("<html><body>It works!</body></html>"))
+	assert.Error(t, err)
+
+	_, err = parseTextStatus([]byte("pool: www\nactive processes: many\n"))
+	assert.Error(t, err)
+}